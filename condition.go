@@ -0,0 +1,237 @@
+package darius
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrSkipped is returned by Spawn when condition in `when` of task is false
+var ErrSkipped = errors.New("task skipped")
+
+// condition evaluates boolean expressions like
+// `${args.env} == "prod" && !${vars.skip}`; expressions are evaluated as
+// operands, so their values are never parsed as part of condition
+type condition struct {
+	state  State
+	source string
+	tokens []string
+	index  int
+}
+
+// Evaluate returns value of condition; condition could be boolean or string
+// with boolean expression
+func Evaluate(state State, raw interface{}) (bool, error) {
+	str, ok := raw.(string)
+	if !ok {
+		return truthy(raw), nil
+	}
+
+	tokens, err := tokenizeCondition(str)
+	if err != nil {
+		return false, err
+	}
+
+	parser := &condition{state: state, source: str, tokens: tokens}
+	result, err := parser.or()
+	if err != nil {
+		return false, err
+	}
+
+	if parser.index != len(tokens) {
+		return false, parser.error("unexpected " + tokens[parser.index])
+	}
+
+	return truthy(result), nil
+}
+
+func tokenizeCondition(str string) ([]string, error) {
+	result := []string{}
+	for index := 0; index < len(str); {
+		char := str[index]
+		rest := str[index:]
+		length := 0
+		switch {
+		case char == ' ' || char == '\t' || char == '\n':
+			index += 1
+			continue
+		case strings.HasPrefix(rest, "${"):
+			length = strings.Index(rest, "}") + 1
+		case char == '"':
+			prefix, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, errors.New("unterminated string in condition: " +
+					str)
+			}
+
+			length = len(prefix)
+		case char == '\'':
+			length = strings.Index(rest[1:], "'")
+			if length != -1 {
+				length += 2
+			}
+		case strings.HasPrefix(rest, "&&") || strings.HasPrefix(rest, "||") ||
+			strings.HasPrefix(rest, "==") || strings.HasPrefix(rest, "!="):
+			length = 2
+		case char == '!' || char == '(' || char == ')':
+			length = 1
+		default:
+			length = strings.IndexAny(rest, " \t\n!=&|()")
+			if length == -1 {
+				length = len(rest)
+			}
+		}
+
+		if length <= 0 {
+			return nil, errors.New("wrong condition " + str + ": unexpected " +
+				rest)
+		}
+
+		result = append(result, rest[:length])
+		index += length
+	}
+
+	return result, nil
+}
+
+func (parser *condition) or() (interface{}, error) {
+	left, err := parser.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.accept("||") {
+		right, err := parser.and()
+		if err != nil {
+			return nil, err
+		}
+
+		left = truthy(left) || truthy(right)
+	}
+
+	return left, nil
+}
+
+func (parser *condition) and() (interface{}, error) {
+	left, err := parser.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.accept("&&") {
+		right, err := parser.not()
+		if err != nil {
+			return nil, err
+		}
+
+		left = truthy(left) && truthy(right)
+	}
+
+	return left, nil
+}
+
+func (parser *condition) not() (interface{}, error) {
+	if parser.accept("!") {
+		value, err := parser.not()
+		if err != nil {
+			return nil, err
+		}
+
+		return !truthy(value), nil
+	}
+
+	return parser.comparison()
+}
+
+func (parser *condition) comparison() (interface{}, error) {
+	left, err := parser.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, operator := range []string{"==", "!="} {
+		if !parser.accept(operator) {
+			continue
+		}
+
+		right, err := parser.operand()
+		if err != nil {
+			return nil, err
+		}
+
+		equal := stringify(left) == stringify(right)
+		return equal == (operator == "=="), nil
+	}
+
+	return left, nil
+}
+
+func (parser *condition) operand() (interface{}, error) {
+	if parser.index >= len(parser.tokens) {
+		return nil, parser.error("unexpected end")
+	}
+
+	token := parser.tokens[parser.index]
+	parser.index += 1
+	switch {
+	case token == "(":
+		value, err := parser.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if !parser.accept(")") {
+			return nil, parser.error("missing )")
+		}
+
+		return value, nil
+	case strings.HasPrefix(token, "${"):
+		return parser.state.Expand(token, false)
+	case strings.HasPrefix(token, "\""):
+		return strconv.Unquote(token)
+	case strings.HasPrefix(token, "'"):
+		return token[1 : len(token)-1], nil
+	case token == "true":
+		return true, nil
+	case token == "false":
+		return false, nil
+	case strings.ContainsAny(token, "!=&|()"):
+		return nil, parser.error("unexpected " + token)
+	}
+
+	return token, nil
+}
+
+func (parser *condition) accept(token string) bool {
+	if parser.index < len(parser.tokens) &&
+		parser.tokens[parser.index] == token {
+		parser.index += 1
+		return true
+	}
+
+	return false
+}
+
+func (parser *condition) error(message string) error {
+	return errors.New("wrong condition " + parser.source + ": " + message)
+}
+
+// truthy treats nil, false, empty string, "false" and zero as false
+func truthy(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return value
+	case int:
+		return value != 0
+	case float64:
+		return value != 0
+	case string:
+		return value != "" && value != "false" && value != "0"
+	case Untrusted:
+		return truthy(string(value))
+	}
+
+	return true
+}
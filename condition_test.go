@@ -0,0 +1,71 @@
+package darius
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newConditionState() *state {
+	state := newTestState()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{
+			"env":    "prod",
+			"deploy": true,
+			"skip":   "false",
+			"name":   "a && b",
+		},
+	}
+
+	return state
+}
+
+func TestEvaluateComparesValues(test *testing.T) {
+	state := newConditionState()
+	defer state.Destroy()
+	result, err := Evaluate(state, `${vars.env} == "prod" && ${vars.deploy}`)
+	assert.NoError(test, err)
+	assert.True(test, result)
+}
+
+func TestEvaluateComparesUntrustedValuesWithoutQuotes(test *testing.T) {
+	state := newConditionState()
+	defer state.Destroy()
+	state.args["branch"] = Untrusted("main")
+	result, err := Evaluate(state, `${args.branch} == "main"`)
+	assert.NoError(test, err)
+	assert.True(test, result)
+}
+
+func TestEvaluateNegatesAndGroups(test *testing.T) {
+	state := newConditionState()
+	defer state.Destroy()
+	result, err := Evaluate(state,
+		`!${vars.skip} && (${vars.env} != 'prod' || false)`)
+	assert.NoError(test, err)
+	assert.False(test, result)
+}
+
+func TestEvaluateDoesNotParseExpandedValues(test *testing.T) {
+	state := newConditionState()
+	defer state.Destroy()
+	result, err := Evaluate(state, `${vars.name} == "a && b"`)
+	assert.NoError(test, err)
+	assert.True(test, result)
+}
+
+func TestEvaluateAcceptsBoolean(test *testing.T) {
+	state := newConditionState()
+	defer state.Destroy()
+	result, err := Evaluate(state, false)
+	assert.NoError(test, err)
+	assert.False(test, result)
+}
+
+func TestEvaluateReportsWrongCondition(test *testing.T) {
+	state := newConditionState()
+	defer state.Destroy()
+	_, err := Evaluate(state, `${vars.env} == `)
+	assert.EqualError(test, err, "wrong condition ${vars.env} == : "+
+		"unexpected end")
+}
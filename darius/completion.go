@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shagabutdinov/arguments"
+)
+
+const (
+	completeCommand = "--complete"
+)
+
+var (
+	completionScripts = map[string]string{
+		"bash": `_darius() {
+    local IFS=$'\n'
+    COMPREPLY=($(darius ` + completeCommand + ` "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+
+complete -o default -F _darius darius
+`,
+
+		"zsh": `#compdef darius
+
+_darius() {
+    local -a candidates
+    candidates=("${(@f)$(darius ` + completeCommand + ` "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    compadd -a candidates
+}
+
+compdef _darius darius
+`,
+
+		"fish": `function __darius_complete
+    set -l words (commandline -opc)
+    darius ` + completeCommand + ` $words[2..-1] (commandline -ct) 2>/dev/null
+end
+
+complete -c darius -f -a '(__darius_complete)'
+`,
+	}
+)
+
+// completion prints completion script for given shell; script calls darius
+// back in order to complete task names and options
+func completion(cli *cli, argv []string) error {
+	if len(argv) != 1 {
+		return errors.New("usage: darius --completion bash|zsh|fish")
+	}
+
+	script, ok := completionScripts[argv[0]]
+	if !ok {
+		return errors.New("unknown shell " + argv[0] + "; bash, zsh and " +
+			"fish are supported")
+	}
+
+	cli.utils.out(script, false)
+	return nil
+}
+
+// complete prints candidates for last of given words; task names are
+// completed until task is set and then options of task are completed
+func complete(cli *cli, words []string) error {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+
+	current := words[len(words)-1]
+	words = words[:len(words)-1]
+
+	runner := cli.newRunner()
+	task := ""
+	for index := 0; index < len(words); index++ {
+		word := words[index]
+		if word == "-c" || word == "--config" {
+			if index+1 < len(words) {
+				runner.ConfigFile = words[index+1]
+			}
+
+			index += 1
+		} else if strings.HasPrefix(word, "--config=") {
+			runner.ConfigFile = strings.TrimPrefix(word, "--config=")
+		} else if !strings.HasPrefix(word, "-") {
+			task = word
+			break
+		}
+	}
+
+	candidates := []string{}
+	tasks, err := runner.Tasks()
+	if err != nil {
+		tasks = map[interface{}]interface{}{}
+	}
+
+	if task != "" {
+		candidates = append(optionNames(taskArguments(tasks[task])), "--help")
+	} else if strings.HasPrefix(current, "-") {
+		candidates = optionNames(options)
+	} else {
+		for key := range tasks {
+			candidates = append(candidates, fmt.Sprint(key))
+		}
+	}
+
+	sort.Strings(candidates)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			cli.utils.out(candidate, true)
+		}
+	}
+
+	return nil
+}
+
+func taskArguments(task interface{}) arguments.Arguments {
+	mapping, _ := task.(map[interface{}]interface{})
+	args, ok := mapping["args"].(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+
+	result, err := arguments.Create(args)
+	if err != nil {
+		return nil
+	}
+
+	return result
+}
+
+func optionNames(definitions arguments.Arguments) []string {
+	result := []string{}
+	for _, argument := range definitions {
+		if argument.Type != arguments.Tail {
+			result = append(result, "--"+argument.Name)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCompletionPrintsScript(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	utils.On("out", mock.MatchedBy(func(script string) bool {
+		return assert.Contains(test, script, "darius --complete")
+	}), false)
+
+	assert.NoError(test, completion(cli, []string{"bash"}))
+	utils.AssertExpectations(test)
+}
+
+func TestCompletionReportsUnknownShell(test *testing.T) {
+	cli, _ := newTestCLI(true)
+	assert.Error(test, completion(cli, []string{"tcsh"}))
+}
+
+func TestCompleteCompletesIncludedTasks(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	utils.On("readFile", "custom.yml").Return(`tasks: "${include
+		more.yml}"`, nil)
+	utils.On("readFile", "more.yml").Return("{deploy: make, "+
+		"debug: make debug, build: make}", nil)
+	utils.On("out", "debug", true)
+	utils.On("out", "deploy", true)
+	assert.NoError(test, complete(cli, []string{"-c", "custom.yml", "de"}))
+	utils.AssertExpectations(test)
+}
+
+func TestCompleteCompletesTaskOptions(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	utils.On("readFile", ".darius.yml").Return("tasks: {deploy: {"+
+		"command: make, args: {branch: {}, force: {type: flag}}}}", nil)
+	utils.On("out", "--force", true)
+	assert.NoError(test, complete(cli, []string{"deploy", "--f"}))
+	utils.AssertExpectations(test)
+}
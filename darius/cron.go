@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul",
+		"aug", "sep", "oct", "nov", "dec"}
+
+	cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	cronFields = []cronField{
+		{"minute", 0, 59, nil},
+		{"hour", 0, 23, nil},
+		{"day of month", 1, 31, nil},
+		{"month", 1, 12, cronMonths},
+		{"day of week", 0, 7, cronWeekdays},
+	}
+)
+
+// schedule is parsed five-field cron expression; every field is bit set of
+// allowed values
+type schedule struct {
+	minute  uint64
+	hour    uint64
+	day     uint64
+	month   uint64
+	weekday uint64
+
+	anyDay     bool
+	anyWeekday bool
+}
+
+func parseSchedule(spec string) (*schedule, error) {
+	spec = strings.TrimSpace(spec)
+	descriptor, ok := cronDescriptors[spec]
+	if ok {
+		spec = descriptor
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, errors.New("schedule should have 5 fields or be one " +
+			"of @yearly, @monthly, @weekly, @daily, @hourly: " + spec)
+	}
+
+	bits := make([]uint64, len(cronFields))
+	for index, field := range cronFields {
+		var err error
+		bits[index], err = field.parse(parts[index])
+		if err != nil {
+			return nil, errors.New("wrong " + field.name + " in schedule \"" +
+				spec + "\": " + err.Error())
+		}
+	}
+
+	// sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &schedule{
+		minute:     bits[0],
+		hour:       bits[1],
+		day:        bits[2],
+		month:      bits[3],
+		weekday:    bits[4],
+		anyDay:     strings.HasPrefix(parts[2], "*"),
+		anyWeekday: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func (field cronField) parse(value string) (uint64, error) {
+	var result uint64
+	for _, part := range strings.Split(value, ",") {
+		step := 1
+		slash := strings.Index(part, "/")
+		if slash != -1 {
+			var err error
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.New("wrong step " + part[slash+1:])
+			}
+
+			part = part[:slash]
+		}
+
+		start, end := field.min, field.max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			start, err = field.value(bounds[0])
+			if err != nil {
+				return 0, err
+			}
+
+			end = start
+			if len(bounds) == 2 {
+				end, err = field.value(bounds[1])
+				if err != nil {
+					return 0, err
+				}
+			} else if slash != -1 {
+				end = field.max
+			}
+		}
+
+		if start > end {
+			return 0, errors.New("wrong range " + part)
+		}
+
+		for current := start; current <= end; current += step {
+			result |= 1 << uint(current)
+		}
+	}
+
+	return result, nil
+}
+
+func (field cronField) value(value string) (int, error) {
+	for index, name := range field.names {
+		if strings.ToLower(value) == name {
+			return index + field.min, nil
+		}
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("wrong value " + value)
+	}
+
+	if result < field.min || result > field.max {
+		return 0, errors.New("value " + value + " is out of range " +
+			strconv.Itoa(field.min) + "-" + strconv.Itoa(field.max))
+	}
+
+	return result, nil
+}
+
+// next returns first time matching schedule strictly after given time
+func (schedule *schedule) next(after time.Time) time.Time {
+	current := after.Truncate(time.Minute).Add(time.Minute)
+	limit := current.AddDate(5, 0, 0)
+
+	for current.Before(limit) {
+		if !schedule.has(schedule.month, int(current.Month())) {
+			current = time.Date(current.Year(), current.Month()+1, 1, 0, 0, 0,
+				0, current.Location())
+			continue
+		}
+
+		if !schedule.matchesDay(current) {
+			current = time.Date(current.Year(), current.Month(),
+				current.Day()+1, 0, 0, 0, 0, current.Location())
+			continue
+		}
+
+		if !schedule.has(schedule.hour, current.Hour()) {
+			current = time.Date(current.Year(), current.Month(),
+				current.Day(), current.Hour()+1, 0, 0, 0, current.Location())
+			continue
+		}
+
+		if !schedule.has(schedule.minute, current.Minute()) {
+			current = current.Add(time.Minute)
+			continue
+		}
+
+		return current
+	}
+
+	return time.Time{}
+}
+
+// matchesDay checks day of month and day of week; as in cron, if both are
+// restricted then matching any of them is enough
+func (schedule *schedule) matchesDay(current time.Time) bool {
+	day := schedule.has(schedule.day, current.Day())
+	weekday := schedule.has(schedule.weekday, int(current.Weekday()))
+	if schedule.anyDay || schedule.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+func (schedule *schedule) has(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cronTime(value string) time.Time {
+	result, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+func assertNext(test *testing.T, spec string, after string, expected string) {
+	schedule, err := parseSchedule(spec)
+	assert.NoError(test, err)
+	assert.Equal(test, cronTime(expected), schedule.next(cronTime(after)))
+}
+
+func TestCronNextReturnsNextMinute(test *testing.T) {
+	assertNext(test, "* * * * *", "2016-01-01 10:00", "2016-01-01 10:01")
+}
+
+func TestCronNextReturnsTimeOfDay(test *testing.T) {
+	assertNext(test, "30 3 * * *", "2016-01-01 10:00", "2016-01-02 03:30")
+}
+
+func TestCronNextSupportsSteps(test *testing.T) {
+	assertNext(test, "*/15 * * * *", "2016-01-01 10:16", "2016-01-01 10:30")
+}
+
+func TestCronNextSupportsRangesAndLists(test *testing.T) {
+	assertNext(test, "0 9-10,15 * * *", "2016-01-01 10:00", "2016-01-01 15:00")
+}
+
+func TestCronNextSupportsWeekdayNames(test *testing.T) {
+	assertNext(test, "0 0 * * mon", "2016-01-01 10:00", "2016-01-04 00:00")
+}
+
+func TestCronNextTreatsSevenAsSunday(test *testing.T) {
+	assertNext(test, "0 0 * * 7", "2016-01-01 10:00", "2016-01-03 00:00")
+}
+
+func TestCronNextMatchesDayOfMonthOrDayOfWeek(test *testing.T) {
+	assertNext(test, "0 0 15 * mon", "2016-01-01 10:00", "2016-01-04 00:00")
+}
+
+func TestCronNextSupportsDescriptors(test *testing.T) {
+	assertNext(test, "@hourly", "2016-01-01 10:20", "2016-01-01 11:00")
+	assertNext(test, "@daily", "2016-01-01 10:20", "2016-01-02 00:00")
+	assertNext(test, "@monthly", "2016-01-01 10:20", "2016-02-01 00:00")
+}
+
+func TestCronNextReturnsZeroIfNeverMatches(test *testing.T) {
+	schedule, err := parseSchedule("0 0 30 2 *")
+	assert.NoError(test, err)
+	assert.True(test, schedule.next(cronTime("2016-01-01 10:00")).IsZero())
+}
+
+func TestCronParseReportsWrongFieldsCount(test *testing.T) {
+	_, err := parseSchedule("* * *")
+	assert.Error(test, err)
+}
+
+func TestCronParseReportsValueOutOfRange(test *testing.T) {
+	_, err := parseSchedule("60 * * * *")
+	assert.EqualError(test, err, `wrong minute in schedule "60 * * * *": `+
+		"value 60 is out of range 0-59")
+}
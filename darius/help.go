@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/idfly/darius"
+	"github.com/shagabutdinov/arguments"
+)
+
+// help prints usage, list of tasks or options of task; it returns false if
+// help was not requested
+func help(
+	cli *cli,
+	runner *darius.Runner,
+	values arguments.Values,
+) (bool, error) {
+	tail, _, err := values.Strings("tail", []string{})
+	if err != nil {
+		return false, err
+	}
+
+	global, _, err := values.Boolean("help", false)
+	if err != nil {
+		return false, err
+	}
+
+	list, _, err := values.Boolean("list", false)
+	if err != nil {
+		return false, err
+	}
+
+	task := len(tail) > 0 && (global || hasHelpOption(tail[1:]))
+	if !global && !list && !task {
+		return false, nil
+	}
+
+	if !list && !task {
+		cli.utils.out(usage(), false)
+		return true, nil
+	}
+
+	runner.ConfigFile, _, err = values.String("config", ".darius.yml")
+	if err != nil {
+		return true, err
+	}
+
+	tasks, err := runner.Tasks()
+	if err != nil {
+		cli.utils.err(err.Error(), true)
+		return true, err
+	}
+
+	if list {
+		cli.utils.out(listTasks(tasks), false)
+		return true, nil
+	}
+
+	text, err := taskHelp(tasks, tail[0])
+	if err != nil {
+		cli.utils.err(err.Error(), true)
+		return true, err
+	}
+
+	cli.utils.out(text, false)
+	return true, nil
+}
+
+func hasHelpOption(argv []string) bool {
+	for _, arg := range argv {
+		if arg == "--help" || arg == "-h" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func usage() string {
+	return "usage: darius [options] TASK [task options]\n" +
+		"       darius --serve [serve options]\n" +
+		"       darius --completion bash|zsh|fish\n\n" +
+		"options:\n" + formatArguments(options) +
+		"\nuse \"darius --list\" to list tasks and \"darius TASK --help\" to " +
+		"show options of task\n"
+}
+
+func listTasks(tasks map[interface{}]interface{}) string {
+	names := []string{}
+	descriptions := map[string]string{}
+	for key, task := range tasks {
+		name := fmt.Sprint(key)
+		names = append(names, name)
+		descriptions[name] = taskName(task)
+	}
+
+	sort.Strings(names)
+
+	rows := [][2]string{}
+	for _, name := range names {
+		rows = append(rows, [2]string{name, descriptions[name]})
+	}
+
+	return "tasks:\n" + formatRows(rows)
+}
+
+func taskHelp(tasks map[interface{}]interface{}, name string) (string, error) {
+	task, ok := tasks[name]
+	if !ok {
+		return "", errors.New("task " + name + " not found in configuration " +
+			"file")
+	}
+
+	result := "usage: darius [options] " + name + " [task options]\n"
+	description := taskName(task)
+	if description != "" {
+		result += "\n" + description + "\n"
+	}
+
+	mapping, _ := task.(map[interface{}]interface{})
+	raw, ok := mapping["args"]
+	if !ok {
+		return result + "\ntask has no options\n", nil
+	}
+
+	args, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		return "", errors.New("args must be map")
+	}
+
+	definitions, err := arguments.Create(args)
+	if err != nil {
+		return "", err
+	}
+
+	return result + "\noptions:\n" + formatArguments(definitions), nil
+}
+
+func taskName(task interface{}) string {
+	mapping, ok := task.(map[interface{}]interface{})
+	if !ok {
+		return ""
+	}
+
+	name, _ := mapping["name"].(string)
+	return name
+}
+
+func formatArguments(definitions arguments.Arguments) string {
+	keys := []string{}
+	for key := range definitions {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	rows := [][2]string{}
+	for _, key := range keys {
+		argument := definitions[key]
+		if argument.Type == arguments.Tail {
+			continue
+		}
+
+		option := "    --" + argument.Name
+		if argument.Shortcut != "" {
+			option = "-" + argument.Shortcut + ", --" + argument.Name
+		}
+
+		if argument.Type != arguments.Flag {
+			option += " VALUE"
+		}
+
+		description := argument.Description
+		if argument.Required {
+			description += " (required)"
+		}
+
+		if argument.Default != nil {
+			description += fmt.Sprintf(" (default: %v)", argument.Default)
+		}
+
+		rows = append(rows, [2]string{option, strings.TrimSpace(description)})
+	}
+
+	return formatRows(rows)
+}
+
+func formatRows(rows [][2]string) string {
+	width := 0
+	for _, row := range rows {
+		if len(row[0]) > width {
+			width = len(row[0])
+		}
+	}
+
+	result := ""
+	for _, row := range rows {
+		line := "  " + row[0]
+		if row[1] != "" {
+			line += strings.Repeat(" ", width-len(row[0])+2) + row[1]
+		}
+
+		result += line + "\n"
+	}
+
+	return result
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHelpPrintsUsage(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	utils.On("out", mock.MatchedBy(func(text string) bool {
+		return regexp.MustCompile(`-c, --config VALUE +configuration file`).
+			MatchString(text)
+	}), false)
+
+	err := call(cli, []string{"--help"})
+	assert.NoError(test, err)
+	utils.AssertExpectations(test)
+}
+
+func TestHelpListsTasks(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	utils.On("readFile", ".darius.yml").Return("tasks: {"+
+		"build: {name: Build project, command: make}, test: make test}", nil)
+	utils.On("out", "tasks:\n  build  Build project\n  test\n", false)
+	err := call(cli, []string{"--list"})
+	assert.NoError(test, err)
+	utils.AssertExpectations(test)
+}
+
+func TestHelpPrintsTaskOptions(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	utils.On("readFile", ".darius.yml").Return("tasks: {deploy: {"+
+		"name: Deploy, command: make, args: {"+
+		"branch: {description: branch to deploy, default: master}, "+
+		"force: {type: flag, shortcut: f, description: skip checks}}}}", nil)
+	utils.On("out", "usage: darius [options] deploy [task options]\n\n"+
+		"Deploy\n\n"+
+		"options:\n"+
+		"      --branch VALUE  branch to deploy (default: master)\n"+
+		"  -f, --force         skip checks\n", false)
+	err := call(cli, []string{"deploy", "--help"})
+	assert.NoError(test, err)
+	utils.AssertExpectations(test)
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/shagabutdinov/arguments"
 )
 
+const (
+	exitInterrupted = 130
+)
+
 var (
 	options = arguments.Arguments{
 		"config": arguments.Argument{
@@ -28,6 +35,16 @@ var (
 			false,
 		},
 
+		"list": arguments.Argument{
+			"list",
+			"lists tasks",
+			arguments.Flag,
+			"",
+			false,
+			nil,
+			false,
+		},
+
 		"local": arguments.Argument{
 			"local",
 			"call all tasks locally",
@@ -38,6 +55,46 @@ var (
 			false,
 		},
 
+		"dry-run": arguments.Argument{
+			"dry-run",
+			"print commands without executing them",
+			arguments.Flag,
+			"n",
+			false,
+			nil,
+			false,
+		},
+
+		"parallel": arguments.Argument{
+			"parallel",
+			"run independent needed tasks in parallel",
+			arguments.Flag,
+			"p",
+			false,
+			nil,
+			false,
+		},
+
+		"var": arguments.Argument{
+			"var",
+			"overrides variable from vars section (key=value)",
+			arguments.String,
+			"V",
+			false,
+			nil,
+			true,
+		},
+
+		"vars-file": arguments.Argument{
+			"vars-file",
+			"overrides variables with values from yaml or json file",
+			arguments.String,
+			"",
+			false,
+			nil,
+			false,
+		},
+
 		"tail": arguments.Argument{
 			"command",
 			"command and its options to execute",
@@ -50,16 +107,62 @@ var (
 	}
 )
 
+var (
+	// commands are set as first option, so they never shadow tasks
+	commands = map[string]func(context.Context, []string) error{
+		"--serve": serve,
+		"--completion": func(ctx context.Context, argv []string) error {
+			return completion(newCLI(), argv)
+		},
+
+		completeCommand: func(ctx context.Context, argv []string) error {
+			return complete(newCLI(), argv)
+		},
+	}
+)
+
 func main() {
-	state, err := newState()
-	if err != nil {
-		panic(err)
+	// first interrupt cancels running tasks and lets ensure sections finish;
+	// second one kills process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	command, ok := findCommand(os.Args[1:])
+	if ok {
+		err := command(ctx, os.Args[2:])
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	cli := newCLI()
+	cli.ctx = ctx
+	err := call(cli, os.Args[1:])
+	if err != nil && ctx.Err() != nil {
+		os.Exit(exitInterrupted)
 	}
 
-	err = call(state, os.Args[1:])
 	if err != nil {
 		os.Exit(1)
 	}
 
 	os.Exit(0)
 }
+
+func findCommand(
+	argv []string,
+) (func(context.Context, []string) error, bool) {
+	if len(argv) == 0 {
+		return nil, false
+	}
+
+	command, ok := commands[argv[0]]
+	return command, ok
+}
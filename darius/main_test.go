@@ -7,48 +7,44 @@ import (
 )
 
 func TestRunTaskSendsStdout(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: "echo test"}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[1;32m$ echo test\x1b[0m", true)
 	utils.On("out", "  > test", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunTaskSendsStderr(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: "echo test 1>&2"}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[1;32m$ echo test 1>&2\x1b[0m", true)
 	utils.On("out", "  \x1b[31m! test\x1b[0m", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunReportsName(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: {name: "NAME", command: "echo test"}}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[1;33m# NAME\x1b[0m", true)
 	utils.On("out", "  \x1b[1;32m$ echo test\x1b[0m", true)
 	utils.On("out", "    > test", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunRunsTwoTasks(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: ["echo test1", "echo test2"]}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[1;32m$ echo test1\x1b[0m", true)
@@ -56,14 +52,13 @@ func TestRunRunsTwoTasks(test *testing.T) {
 	utils.On("out", "\x1b[1;32m$ echo test2\x1b[0m", true)
 	utils.On("out", "  > test2", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunRunsTwoTasksWithName(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: {name: "NAME", command: ["echo test1", "echo test2"]}}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[1;33m# NAME\x1b[0m", true)
@@ -72,26 +67,24 @@ func TestRunRunsTwoTasksWithName(test *testing.T) {
 	utils.On("out", "  \x1b[1;32m$ echo test2\x1b[0m", true)
 	utils.On("out", "    > test2", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunChecksContext(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: {command: "echo test1", context: "/bin/false"}}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[36m? /bin/false\x1b[0m", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunRunsRescue(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: {command: "/bin/false", rescue: "echo RESCUE"}}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[1;32m$ /bin/false\x1b[0m", true)
@@ -101,14 +94,13 @@ func TestRunRunsRescue(test *testing.T) {
 	utils.On("out", "\x1b[1;32m$ echo RESCUE\x1b[0m", true)
 	utils.On("out", "  > RESCUE", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunRunsEnsure(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: {command: "/bin/false", ensure: "echo ENSURE"}}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[1;32m$ /bin/false\x1b[0m", true)
@@ -119,14 +111,33 @@ func TestRunRunsEnsure(test *testing.T) {
 	utils.On("out", "  > ENSURE", true)
 	utils.On("out", "\x1b[1;37;41m ** task execution failed (check logs for "+
 		"details) ** \x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.Error(test, err)
 	utils.AssertExpectations(test)
 }
 
+func TestRunPrintsPlanInDryRun(test *testing.T) {
+	cli, utils := newTestCLI(false)
+	tasks := `{task: {host: "${vars.host}", context: "test -f /tmp/lock",
+		command: "rm -rf /", rescue: "echo RESCUE", ensure: "echo ENSURE"}}`
+	utils.On("readFile", ".darius.yml").Return("vars: {host: prod}\n"+
+		"tasks: "+tasks, nil)
+	utils.On("out", "\x1b[35m% connection to prod skipped (dry run)\x1b[0m",
+		true)
+	utils.On("out", "\x1b[36m? test -f /tmp/lock\x1b[0m", true)
+	utils.On("out", "\x1b[1;32m$ rm -rf /\x1b[0m", true)
+	utils.On("out", "\x1b[1;33m[rescue]\x1b[0m", true)
+	utils.On("out", "\x1b[1;32m$ echo RESCUE\x1b[0m", true)
+	utils.On("out", "\x1b[1;33m[ensure]\x1b[0m", true)
+	utils.On("out", "\x1b[1;32m$ echo ENSURE\x1b[0m", true)
+	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
+	err := call(cli, []string{"--dry-run", "task"})
+	assert.NoError(test, err)
+	utils.AssertExpectations(test)
+}
+
 func TestRunRunsOnHost(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: {host: "ssh.darius.local", command: "cat /etc/hostname"}}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
 	utils.On("out", "\x1b[35m% connecting to ssh.darius.local...\x1b[0m",
@@ -135,14 +146,13 @@ func TestRunRunsOnHost(test *testing.T) {
 	utils.On("out", "\x1b[1;32m$ cat /etc/hostname\x1b[0m", true)
 	utils.On("out", "  > ssh.darius.local", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunRunsOnHostWithKeyFile(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	tasks := `{task: {host: {host: "user@ssh.darius.local",
 		key: "/root/.ssh/user"}, command: "whoami"}}`
 	utils.On("readFile", ".darius.yml").Return("tasks: "+tasks, nil)
@@ -152,42 +162,39 @@ func TestRunRunsOnHostWithKeyFile(test *testing.T) {
 	utils.On("out", "\x1b[1;32m$ whoami\x1b[0m", true)
 	utils.On("out", "  > user", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunIncludesConfiguration(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	utils.On("readFile", "FILE").Return(`{task: "echo test"}`, nil)
 	utils.On("readFile", ".darius.yml").Return(`tasks: "${include
 		FILE}"`, nil)
 	utils.On("out", "\x1b[1;32m$ echo test\x1b[0m", true)
 	utils.On("out", "  > test", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunIncludesConfigurationGlob(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	utils.On("readFile", "FILE").Return(`{task: "echo test"}`, nil)
 	utils.On("readFile", ".darius.yml").Return(`tasks: "${include P/*}"`, nil)
 	utils.On("glob", "P/*").Return([]string{"FILE"}, nil)
 	utils.On("out", "\x1b[1;32m$ echo test\x1b[0m", true)
 	utils.On("out", "  > test", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunRunsNestedWithNameAndContext(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
+	cli, utils := newTestCLI(false)
 	utils.On("readFile", ".darius.yml").Return(`
 tasks:
   task:
@@ -203,15 +210,13 @@ tasks:
 	utils.On("out", "  \x1b[1;32m$ echo 2\x1b[0m", true)
 	utils.On("out", "    > 2", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunExpandsArgument(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
-	state.argv = []string{"--arg", "VALUE"}
+	cli, utils := newTestCLI(false)
 	utils.On("readFile", ".darius.yml").Return(`
 tasks:
   task:
@@ -221,15 +226,13 @@ tasks:
 	utils.On("out", "\x1b[1;32m$ echo VALUE\x1b[0m", true)
 	utils.On("out", "  > VALUE", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task", "--arg", "VALUE"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunExpandsArgumentByParam(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
-	state.argv = []string{}
+	cli, utils := newTestCLI(false)
 	utils.On("readFile", ".darius.yml").Return(`
 tasks:
   task:
@@ -241,15 +244,13 @@ tasks:
 	utils.On("out", "\x1b[1;32m$ echo VALUE\x1b[0m", true)
 	utils.On("out", "  > VALUE", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
 
 func TestRunRunsUserTask(test *testing.T) {
-	state, utils := newTestState(false)
-	defer state.Destroy()
-	state.argv = []string{}
+	cli, utils := newTestCLI(false)
 	utils.On("readFile", ".darius.yml").Return(`
 tasks:
   task:
@@ -261,7 +262,14 @@ tasks:
 	utils.On("out", "\x1b[1;32m$ echo VALUE\x1b[0m", true)
 	utils.On("out", "  > VALUE", true)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"task"})
+	err := call(cli, []string{"task"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
+
+func TestFindCommandDoesNotShadowTasks(test *testing.T) {
+	_, ok := findCommand([]string{"serve"})
+	assert.False(test, ok)
+	_, ok = findCommand([]string{"--serve", "--listen", ":8080"})
+	assert.True(test, ok)
+}
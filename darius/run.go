@@ -1,103 +1,140 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/idfly/darius"
+	"github.com/idfly/darius/jobs"
 	"github.com/shagabutdinov/arguments"
 )
 
-func call(state *state, optionsArray []string) error {
+type cli struct {
+	ctx   context.Context
+	utils utilsInterface
+	jobs  map[string]darius.Job
+}
+
+func newCLI() *cli {
+	return &cli{
+		ctx: context.Background(),
+		utils: &utils{
+			stdout: os.Stdout,
+			stderr: os.Stderr,
+		},
+		jobs: jobs.Funcs,
+	}
+}
+
+func (cli *cli) newRunner() *darius.Runner {
+	return &darius.Runner{
+		Loader: darius.Config{
+			ReadFile: cli.utils.readFile,
+			Glob:     cli.utils.glob,
+		},
+		Output: cli.output,
+		Jobs:   cli.jobs,
+	}
+}
+
+func (cli *cli) output(level darius.LogLevel, indent int, message string) {
+	cli.utils.out(darius.FormatLog(level, indent, message), true)
+}
+
+func call(cli *cli, optionsArray []string) error {
 	arguments, err := options.Parse(optionsArray)
 	if err != nil {
-		state.utils.err(err.Error(), true)
+		cli.utils.err(err.Error(), true)
 		os.Exit(1)
 	}
 
-	err = runTask(state, arguments)
+	runner := cli.newRunner()
+	shown, err := help(cli, runner, arguments)
+	if shown {
+		return err
+	}
+
+	err = runTask(cli, runner, arguments)
+	report(runner, err)
+	return err
+}
 
-	if err != nil {
-		state.Log(darius.LogTaskFail, " ** task execution failed (check logs "+
+func report(runner *darius.Runner, err error) {
+	if err == context.Canceled {
+		runner.Log(darius.LogTaskFail, " ** task interrupted ** ")
+	} else if err != nil {
+		runner.Log(darius.LogTaskFail, " ** task execution failed (check logs "+
 			"for details) ** ")
 	} else {
-		state.Log(darius.LogTaskSuccess, "task completed")
+		runner.Log(darius.LogTaskSuccess, "task completed")
 	}
-
-	return err
 }
 
-func runTask(state *state, arguments arguments.Values) error {
+func runTask(
+	cli *cli,
+	runner *darius.Runner,
+	arguments arguments.Values,
+) error {
 	tail, _, err := arguments.Strings("tail", []string{})
 	if len(tail) > 0 && strings.HasPrefix(tail[0], "-") {
 		return errors.New("unknown option " + tail[0])
 	}
 
-	state.argv = tail[1:]
+	if len(tail) > 0 {
+		runner.Argv = tail[1:]
+	}
 
-	state.runLocally, _, err = arguments.Boolean("local", false)
+	runner.Local, _, err = arguments.Boolean("local", false)
 	if err != nil {
 		return err
 	}
 
-	help, _, err := arguments.Boolean("help", false)
+	runner.Parallel, _, err = arguments.Boolean("parallel", false)
 	if err != nil {
 		return err
 	}
 
-	if help {
-		return errors.New("help is not available yet")
-	}
-
-	configLoader := darius.Config{
-		ReadFile: state.utils.readFile,
-		Glob:     state.utils.glob,
-	}
-
-	configFile, _, err := arguments.String("config", ".darius.yml")
+	runner.DryRun, _, err = arguments.Boolean("dry-run", false)
 	if err != nil {
 		return err
 	}
 
-	config, err := configLoader.Load(configFile)
+	runner.ConfigFile, _, err = arguments.String("config", ".darius.yml")
 	if err != nil {
 		return err
 	}
 
-	state.config = config
+	jobs.FingerprintDir = fingerprintDir(runner.ConfigFile)
 
-	tasksRaw, ok := config["tasks"]
-	if !ok {
-		return errors.New("tasks section must be set in config")
-	}
-
-	tasksExpanded, err := state.Expand(tasksRaw, false)
+	runner.Vars, err = parseVars(cli, arguments)
 	if err != nil {
 		return err
 	}
 
-	tasks, ok := tasksExpanded.(map[interface{}]interface{})
-	if !ok {
-		return errors.New("tasks section must be map")
+	_, err = runner.Tasks()
+	if err != nil {
+		return err
 	}
 
 	if len(tail) == 0 {
-		state.utils.err("task must be set in command line options; use "+
+		cli.utils.err("task must be set in command line options; use "+
 			"--help to receive help", true)
 		return errors.New("no task provided")
 	}
 
-	task, ok := tasks[tail[0]]
-	if !ok {
-		return errors.New("task " + tail[0] + " not found in configuration " +
-			"file")
-	}
-
-	err = state.Call("call", darius.CreateTask(task))
+	_, err = runner.Run(cli.ctx, tail[0])
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// fingerprintDir returns directory of fingerprints next to configuration file,
+// so tasks are skipped the same way when darius is run from subdirectory
+func fingerprintDir(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), ".darius", "fingerprints")
+}
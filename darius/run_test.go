@@ -1,30 +1,91 @@
 package main
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestRunReportsErrorIfNoTaskProvided(test *testing.T) {
-	state, utils := newTestState(true)
-	defer state.Destroy()
+	cli, utils := newTestCLI(true)
 	utils.On("readFile", ".darius.yml").Return("tasks: {}", nil)
 	utils.On("err", "task must be set in command line options; use --help "+
 		"to receive help", true)
 	utils.On("out", "\x1b[1;37;41m ** task execution failed (check logs for "+
 		"details) ** \x1b[0m", true)
-	err := call(state, []string{})
+	err := call(cli, []string{})
 	assert.Error(test, err, "task must be set")
 }
 
 func TestRunRunsTask(test *testing.T) {
-	state, utils := newTestState(true)
-	defer state.Destroy()
+	cli, utils := newTestCLI(true)
 	utils.On("readFile", ".darius.yml").Return("tasks: {T: TASK}", nil)
 	utils.On("call", map[interface{}]interface{}{"command": "TASK"}).Return(nil)
 	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
-	err := call(state, []string{"T"})
+	err := call(cli, []string{"T"})
 	assert.NoError(test, err)
 	utils.AssertExpectations(test)
 }
+
+func TestRunReportsInterruptedTask(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cli.ctx = ctx
+	utils.On("readFile", ".darius.yml").Return("tasks: {T: TASK}", nil)
+	utils.On("out", "\x1b[1;37;41m ** task interrupted ** \x1b[0m", true)
+	err := call(cli, []string{"T"})
+	assert.Equal(test, context.Canceled, err)
+	utils.AssertExpectations(test)
+}
+
+func TestRunOverridesVars(test *testing.T) {
+	cli, utils := newTestCLI(false)
+	utils.On("readFile", ".darius.yml").Return("vars: {env: dev, build: 0}\n"+
+		"tasks: {T: 'echo ${vars.env} ${vars.image.tag} ${vars.build}'}", nil)
+	utils.On("readFile", "vars.json").Return(`{"build": 42}`, nil)
+	utils.On("out", "\x1b[1;32m$ echo staging abc 42\x1b[0m", true)
+	utils.On("out", "  > staging abc 42", true)
+	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
+	err := call(cli, []string{"-V", "env=staging", "--var", "image.tag=abc",
+		"--vars-file", "vars.json", "T"})
+	assert.NoError(test, err)
+	utils.AssertExpectations(test)
+}
+
+func TestRunReportsWrongVar(test *testing.T) {
+	cli, utils := newTestCLI(true)
+	utils.On("out", mock.Anything, true)
+	err := call(cli, []string{"-V", "env", "T"})
+	assert.EqualError(test, err, "var should be set as key=value: env")
+}
+
+func TestRunRunsScriptWithEscapedExpression(test *testing.T) {
+	cli, utils := newTestCLI(false)
+	utils.On("readFile", ".darius.yml").Return("tasks: {T: {script: "+
+		"'X=1; echo \"$${X}\"'}}", nil)
+	utils.On("out", "  > 1", true)
+	utils.On("out", mock.Anything, true)
+	err := call(cli, []string{"T"})
+	assert.NoError(test, err)
+	utils.AssertExpectations(test)
+}
+
+func TestRunExpandsShellExpressionInVars(test *testing.T) {
+	cli, utils := newTestCLI(false)
+	utils.On("readFile", ".darius.yml").Return("vars: {revision: "+
+		"'${shell echo abc}'}\ntasks: {T: {name: 'deploy ${vars.revision}', "+
+		"command: 'echo ${vars.revision}'}}", nil)
+	utils.On("out", "    > abc", true)
+	utils.On("out", mock.Anything, true)
+	err := call(cli, []string{"T"})
+	assert.NoError(test, err)
+	utils.AssertExpectations(test)
+}
+
+func TestFingerprintDirIsNextToConfigFile(test *testing.T) {
+	assert.Equal(test, "project/.darius/fingerprints",
+		fingerprintDir("project/.darius.yml"))
+}
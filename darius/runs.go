@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/idfly/darius"
+)
+
+const (
+	runQueued    = "queued"
+	runRunning   = "running"
+	runSucceeded = "succeeded"
+	runFailed    = "failed"
+	runCancelled = "cancelled"
+
+	// runCancelling is reported by cancel endpoint only and never stored
+	runCancelling = "cancelling"
+
+	scheduleFile = "schedule"
+)
+
+type logLine struct {
+	Level   darius.LogLevel `json:"level"`
+	Indent  int             `json:"indent"`
+	Message string          `json:"message"`
+}
+
+type run struct {
+	ID          string                 `json:"id"`
+	Task        string                 `json:"task"`
+	Argv        []string               `json:"argv"`
+	Args        map[string]interface{} `json:"args"`
+	Concurrency int                    `json:"concurrency"`
+	State       string                 `json:"state"`
+	Status      int                    `json:"status"`
+	Error       string                 `json:"error,omitempty"`
+	Created     time.Time              `json:"created"`
+	Started     *time.Time             `json:"started,omitempty"`
+	Finished    *time.Time             `json:"finished,omitempty"`
+	Log         []logLine              `json:"log,omitempty"`
+
+	updated chan struct{}
+	cancel  context.CancelFunc
+}
+
+func (run *run) finished() bool {
+	return run.State == runSucceeded || run.State == runFailed ||
+		run.State == runCancelled
+}
+
+// finish sets state of run; status of cancelled run is 130 like for
+// interrupted task in command line
+func (run *run) finish(state string, status int, err error) {
+	finished := time.Now()
+	run.State = state
+	run.Finished = &finished
+	run.Status = status
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if state == runCancelled {
+		run.Status = exitInterrupted
+	}
+
+	run.notify()
+}
+
+func (run *run) append(line logLine) {
+	run.Log = append(run.Log, line)
+	run.notify()
+}
+
+// updates returns channel that is closed on next change of run
+func (run *run) updates() <-chan struct{} {
+	if run.updated == nil {
+		run.updated = make(chan struct{})
+	}
+
+	return run.updated
+}
+
+func (run *run) notify() {
+	if run.updated != nil {
+		close(run.updated)
+		run.updated = nil
+	}
+}
+
+type runStore struct {
+	dir string
+}
+
+func (store runStore) load() ([]*run, error) {
+	files, err := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*run{}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		current := &run{}
+		err = json.Unmarshal(contents, current)
+		if err != nil {
+			return nil, errors.New("failed to load run " + file + ": " +
+				err.Error())
+		}
+
+		result = append(result, current)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+
+	return result, nil
+}
+
+func (store runStore) save(run *run) error {
+	err := os.MkdirAll(store.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	file := store.file(run.ID)
+	err = ioutil.WriteFile(file+".tmp", contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file+".tmp", file)
+}
+
+func (store runStore) loadSchedule() (map[string]time.Time, error) {
+	result := map[string]time.Time{}
+	contents, err := ioutil.ReadFile(filepath.Join(store.dir, scheduleFile))
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (store runStore) saveSchedule(scheduled map[string]time.Time) error {
+	err := os.MkdirAll(store.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(scheduled)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(store.dir, scheduleFile)
+	err = ioutil.WriteFile(file+".tmp", contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file+".tmp", file)
+}
+
+func (store runStore) remove(id string) error {
+	err := os.Remove(store.file(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (store runStore) file(id string) string {
+	return filepath.Join(store.dir, strings.Replace(id, "/", "", -1)+".json")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	missedSkip    = "skip"
+	missedRunOnce = "run-once"
+)
+
+type taskSchedule struct {
+	schedule *schedule
+	missed   string
+}
+
+func parseTaskSchedule(task interface{}) (*taskSchedule, error) {
+	mapping, ok := task.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	raw, ok := mapping["schedule"]
+	if !ok {
+		return nil, nil
+	}
+
+	result := &taskSchedule{missed: missedSkip}
+	spec, ok := raw.(string)
+	if !ok {
+		config, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("schedule should be string or map")
+		}
+
+		spec, ok = config["cron"].(string)
+		if !ok {
+			return nil, errors.New("cron should be set in schedule section")
+		}
+
+		missed, ok := config["missed"]
+		if ok {
+			result.missed, ok = missed.(string)
+			if !ok || (result.missed != missedSkip &&
+				result.missed != missedRunOnce) {
+				return nil, errors.New("missed should be \"" + missedSkip +
+					"\" or \"" + missedRunOnce + "\"")
+			}
+		}
+	}
+
+	var err error
+	result.schedule, err = parseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// schedule triggers scheduled tasks at start of every minute until context
+// is done
+func (server *server) schedule(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		server.tick(now)
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).
+			Sub(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (server *server) tick(now time.Time) {
+	tasks, err := server.newRunner().Tasks()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	changed := false
+	for key, task := range tasks {
+		name, ok := key.(string)
+		if !ok {
+			continue
+		}
+
+		schedule, err := parseTaskSchedule(task)
+		if err != nil {
+			log.Println("task " + name + ": " + err.Error())
+			continue
+		}
+
+		if schedule == nil {
+			continue
+		}
+
+		last, ok := server.scheduled[name]
+		if !ok {
+			server.scheduled[name] = now
+			changed = true
+			continue
+		}
+
+		latest := time.Time{}
+		next := schedule.schedule.next(last)
+		for !next.IsZero() && !next.After(now) {
+			latest = next
+			next = schedule.schedule.next(next)
+		}
+
+		if latest.IsZero() {
+			continue
+		}
+
+		server.scheduled[name] = latest
+		changed = true
+
+		missed := now.Sub(latest) >= time.Minute
+		if missed && schedule.missed == missedSkip {
+			log.Println("missed run of task " + name + " at " +
+				latest.Format(time.RFC3339) + " skipped")
+			continue
+		}
+
+		args := map[string]interface{}{"trigger": "schedule"}
+		_, err = server.submit(name, task, []string{}, args)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	if changed {
+		err = server.store.saveSchedule(server.scheduled)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestScheduleParsesMap(test *testing.T) {
+	schedule, err := parseTaskSchedule(map[interface{}]interface{}{
+		"schedule": map[interface{}]interface{}{
+			"cron":   "@daily",
+			"missed": "run-once",
+		},
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, missedRunOnce, schedule.missed)
+}
+
+func TestScheduleReportsWrongMissedPolicy(test *testing.T) {
+	_, err := parseTaskSchedule(map[interface{}]interface{}{
+		"schedule": map[interface{}]interface{}{
+			"cron":   "@daily",
+			"missed": "UNKNOWN",
+		},
+	})
+
+	assert.Error(test, err)
+}
+
+func TestScheduleStopsWhenContextIsDone(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return("tasks: {}", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		server.schedule(ctx)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		test.Fatal("scheduler did not stop")
+	}
+}
+
+func TestScheduleTriggersDueTask(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(
+		`tasks: {T: {schedule: "0 3 * * *", command: TASK}}`, nil)
+	utils.On("call", mock.Anything).Return(nil)
+	utils.On("out", mock.Anything, true)
+
+	server.tick(cronTime("2016-01-01 02:59"))
+	assert.Empty(test, server.list())
+
+	server.tick(cronTime("2016-01-01 03:00"))
+	server.active.Wait()
+
+	runs := server.list()
+	assert.Len(test, runs, 1)
+	assert.Equal(test, "schedule", runs[0].Args["trigger"])
+	assert.Equal(test, runSucceeded, runs[0].State)
+
+	scheduled, err := server.store.loadSchedule()
+	assert.NoError(test, err)
+	assert.True(test, cronTime("2016-01-01 03:00").Equal(scheduled["T"]))
+}
+
+func TestScheduleSkipsMissedRuns(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(
+		`tasks: {T: {schedule: "0 3 * * *", command: TASK}}`, nil)
+
+	server.scheduled["T"] = cronTime("2016-01-01 00:00")
+	server.tick(cronTime("2016-01-03 10:00"))
+
+	assert.Empty(test, server.list())
+	assert.Equal(test, cronTime("2016-01-03 03:00"), server.scheduled["T"])
+}
+
+func TestScheduleRunsMissedRunOnce(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(`tasks: {T: {schedule: `+
+		`{cron: "0 3 * * *", missed: run-once}, command: TASK}}`, nil)
+	utils.On("call", mock.Anything).Return(nil)
+	utils.On("out", mock.Anything, true)
+
+	server.scheduled["T"] = cronTime("2016-01-01 00:00")
+	server.tick(cronTime("2016-01-03 10:00"))
+	server.active.Wait()
+
+	assert.Len(test, server.list(), 1)
+}
+
+func TestScheduleStartsFromNowForNewTask(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(
+		`tasks: {T: {schedule: "* * * * *", command: TASK}}`, nil)
+
+	now := time.Now()
+	server.tick(now)
+
+	assert.Empty(test, server.list())
+	assert.Equal(test, now, server.scheduled["T"])
+}
@@ -0,0 +1,746 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/idfly/darius"
+	"github.com/idfly/darius/jobs"
+	"github.com/shagabutdinov/arguments"
+)
+
+const (
+	maxPayloadSize = 10 << 20
+)
+
+var (
+	serveOptions = arguments.Arguments{
+		"config": arguments.Argument{
+			"config",
+			"configuration file",
+			arguments.String,
+			"c",
+			false,
+			nil,
+			false,
+		},
+
+		"listen": arguments.Argument{
+			"listen",
+			"address to listen for webhooks on",
+			arguments.String,
+			"",
+			false,
+			nil,
+			false,
+		},
+
+		"local": arguments.Argument{
+			"local",
+			"call all tasks locally",
+			arguments.Flag,
+			"l",
+			false,
+			nil,
+			false,
+		},
+
+		"runs": arguments.Argument{
+			"runs",
+			"directory to store run history in",
+			arguments.String,
+			"",
+			false,
+			nil,
+			false,
+		},
+
+		"retention": arguments.Argument{
+			"retention",
+			"number of finished runs to keep",
+			arguments.String,
+			"",
+			false,
+			nil,
+			false,
+		},
+	}
+)
+
+// serve runs http server until ctx is done; then running tasks are cancelled
+// and server waits for their ensure sections
+func serve(ctx context.Context, optionsArray []string) error {
+	arguments, err := serveOptions.Parse(optionsArray)
+	if err != nil {
+		return err
+	}
+
+	configFile, _, err := arguments.String("config", ".darius.yml")
+	if err != nil {
+		return err
+	}
+
+	listen, _, err := arguments.String("listen", ":8080")
+	if err != nil {
+		return err
+	}
+
+	local, _, err := arguments.Boolean("local", false)
+	if err != nil {
+		return err
+	}
+
+	runsDir, _, err := arguments.String("runs", ".darius/runs")
+	if err != nil {
+		return err
+	}
+
+	retentionRaw, _, err := arguments.String("retention", "100")
+	if err != nil {
+		return err
+	}
+
+	retention, err := strconv.Atoi(retentionRaw)
+	if err != nil || retention < 0 {
+		return errors.New("retention should be non-negative number")
+	}
+
+	jobs.FingerprintDir = fingerprintDir(configFile)
+	server := newServer(newCLI(), configFile, runsDir)
+	server.ctx = ctx
+	server.local = local
+	server.retention = retention
+
+	err = server.restore()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go server.schedule(ctx)
+
+	httpServer := &http.Server{Addr: listen, Handler: server}
+	go func() {
+		<-ctx.Done()
+		err := httpServer.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
+	err = httpServer.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	server.active.Wait()
+	return nil
+}
+
+type server struct {
+	ctx       context.Context
+	config    string
+	local     bool
+	retention int
+	cli       *cli
+	store     runStore
+
+	mutex     sync.Mutex
+	runs      map[string]*run
+	running   map[string]int
+	active    sync.WaitGroup
+	scheduled map[string]time.Time
+}
+
+func newServer(cli *cli, config string, runsDir string) *server {
+	return &server{
+		ctx:       context.Background(),
+		config:    config,
+		cli:       cli,
+		store:     runStore{dir: runsDir},
+		runs:      map[string]*run{},
+		running:   map[string]int{},
+		scheduled: map[string]time.Time{},
+	}
+}
+
+func (server *server) ServeHTTP(
+	writer http.ResponseWriter,
+	request *http.Request,
+) {
+	path := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+
+	switch {
+	case len(path) == 2 && path[0] == "tasks":
+		if !allowMethod(writer, request, http.MethodPost) {
+			return
+		}
+
+		server.trigger(writer, request, path[1])
+	case len(path) == 1 && path[0] == "runs":
+		if !allowMethod(writer, request, http.MethodGet) {
+			return
+		}
+
+		writeJSON(writer, http.StatusOK, server.list())
+	case len(path) == 2 && path[0] == "runs":
+		if !allowMethod(writer, request, http.MethodGet) {
+			return
+		}
+
+		current, ok := server.get(path[1])
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+
+		writeJSON(writer, http.StatusOK, current)
+	case len(path) == 3 && path[0] == "runs" && path[2] == "log":
+		if !allowMethod(writer, request, http.MethodGet) {
+			return
+		}
+
+		server.stream(writer, request, path[1])
+	case len(path) == 3 && path[0] == "runs" && path[2] == "cancel":
+		if !allowMethod(writer, request, http.MethodPost) {
+			return
+		}
+
+		status, err := server.cancel(path[1])
+		if err != nil {
+			http.Error(writer, err.Error(), status)
+			return
+		}
+
+		state := runCancelled
+		if status == http.StatusAccepted {
+			state = runCancelling
+		}
+
+		writeJSON(writer, status, map[string]string{
+			"id":    path[1],
+			"state": state,
+		})
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+func (server *server) trigger(
+	writer http.ResponseWriter,
+	request *http.Request,
+	name string,
+) {
+	task, argv, args, status, err := server.prepare(name, writer, request)
+	if err != nil {
+		http.Error(writer, err.Error(), status)
+		return
+	}
+
+	id, err := server.submit(name, task, argv, args)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, http.StatusAccepted, map[string]string{
+		"id":    id,
+		"state": runQueued,
+	})
+}
+
+func (server *server) submit(
+	name string,
+	task interface{},
+	argv []string,
+	args map[string]interface{},
+) (string, error) {
+	concurrency, err := taskConcurrency(task)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := newRunID()
+	if err != nil {
+		return "", err
+	}
+
+	err = server.enqueue(&run{
+		ID:          id,
+		Task:        name,
+		Argv:        argv,
+		Args:        args,
+		Concurrency: concurrency,
+		State:       runQueued,
+		Status:      -1,
+		Created:     time.Now(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// prepare verifies request to task and returns task with its arguments;
+// query is not covered by signature, so it is passed only to tasks without
+// secret
+func (server *server) prepare(
+	name string,
+	writer http.ResponseWriter,
+	request *http.Request,
+) (interface{}, []string, map[string]interface{}, int, error) {
+	runner := server.newRunner()
+	tasks, err := runner.Tasks()
+	if err != nil {
+		return nil, nil, nil, http.StatusInternalServerError, err
+	}
+
+	task, ok := tasks[name]
+	if !ok {
+		return nil, nil, nil, http.StatusNotFound, errors.New("task " + name +
+			" not found in configuration file")
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body,
+		maxPayloadSize))
+	if err != nil {
+		return nil, nil, nil, http.StatusBadRequest, err
+	}
+
+	webhook, err := newWebhook(runner, task)
+	if err != nil {
+		return nil, nil, nil, http.StatusInternalServerError, err
+	}
+
+	if webhook == nil {
+		return nil, nil, nil, http.StatusForbidden, errors.New("task " +
+			name + " has no webhook secret; set webhook insecure in order " +
+			"to run it without signature")
+	}
+
+	argv := []string{}
+	if webhook.secret == "" {
+		argv = queryToArgv(request)
+	} else {
+		err = webhook.verify(request.Header, body)
+		if err != nil {
+			return nil, nil, nil, http.StatusUnauthorized, err
+		}
+	}
+
+	args := map[string]interface{}{"trigger": "webhook"}
+	provider := webhook.detect(request.Header)
+	for key, value := range parseWebhookPayload(provider, body) {
+		args[key.(string)] = value
+	}
+
+	return task, argv, args, http.StatusOK, nil
+}
+
+func taskConcurrency(task interface{}) (int, error) {
+	mapping, ok := task.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil
+	}
+
+	raw, ok := mapping["concurrency"]
+	if !ok {
+		return 0, nil
+	}
+
+	concurrency, ok := raw.(int)
+	if !ok || concurrency < 0 {
+		return 0, errors.New("concurrency should be non-negative number")
+	}
+
+	return concurrency, nil
+}
+
+func (server *server) restore() error {
+	runs, err := server.store.load()
+	if err != nil {
+		return err
+	}
+
+	server.scheduled, err = server.store.loadSchedule()
+	if err != nil {
+		return err
+	}
+
+	server.mutex.Lock()
+	for _, current := range runs {
+		if current.State == runRunning {
+			current.finish(runFailed, 1, errors.New("interrupted by "+
+				"server restart"))
+			server.save(current)
+		}
+
+		server.runs[current.ID] = current
+	}
+
+	server.prune()
+	server.mutex.Unlock()
+
+	server.dispatch()
+	return nil
+}
+
+func (server *server) enqueue(current *run) error {
+	server.mutex.Lock()
+	err := server.store.save(current)
+	if err == nil {
+		server.runs[current.ID] = current
+	}
+
+	server.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	server.dispatch()
+	return nil
+}
+
+func (server *server) dispatch() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.ctx.Err() != nil {
+		return
+	}
+
+	for _, current := range server.sorted() {
+		if current.State != runQueued {
+			continue
+		}
+
+		running := server.running[current.Task]
+		if current.Concurrency > 0 && running >= current.Concurrency {
+			continue
+		}
+
+		current.State = runRunning
+		started := time.Now()
+		current.Started = &started
+		server.running[current.Task] += 1
+		server.save(current)
+
+		ctx, cancel := context.WithCancel(server.ctx)
+		current.cancel = cancel
+
+		server.active.Add(1)
+		go server.execute(ctx, current)
+	}
+}
+
+func (server *server) execute(ctx context.Context, current *run) {
+	defer server.active.Done()
+
+	status, err := server.call(ctx, current)
+
+	server.mutex.Lock()
+	if ctx.Err() != nil {
+		current.finish(runCancelled, status, err)
+	} else if err != nil {
+		current.finish(runFailed, status, err)
+	} else {
+		current.finish(runSucceeded, status, nil)
+	}
+
+	current.cancel()
+	current.cancel = nil
+
+	server.running[current.Task] -= 1
+	server.save(current)
+	server.prune()
+	server.mutex.Unlock()
+
+	server.dispatch()
+}
+
+func (server *server) call(ctx context.Context, current *run) (int, error) {
+	runner := server.newRunner()
+	runner.Argv = current.Argv
+	runner.Args = map[interface{}]interface{}{}
+	for key, value := range current.Args {
+		// arguments of run come from payload, so they are quoted when
+		// expanded in commands
+		str, ok := value.(string)
+		if ok {
+			value = darius.Untrusted(str)
+		}
+
+		runner.Args[key] = value
+	}
+
+	runner.Output = func(level darius.LogLevel, indent int, message string) {
+		server.cli.output(level, indent, message)
+		server.mutex.Lock()
+		current.append(logLine{level, indent, message})
+		server.mutex.Unlock()
+	}
+
+	runner.Log(darius.LogSystem, "run "+current.ID+" of task "+current.Task+
+		" started")
+
+	result, err := runner.Run(ctx, current.Task)
+	report(runner, err)
+	return result.Status, err
+}
+
+func (server *server) newRunner() *darius.Runner {
+	runner := server.cli.newRunner()
+	runner.ConfigFile = server.config
+	runner.Local = server.local
+	return runner
+}
+
+// stream sends log of run as server-sent events; lines emitted before
+// subscription are replayed; stream is closed after run is finished
+func (server *server) stream(
+	writer http.ResponseWriter,
+	request *http.Request,
+	id string,
+) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming is not supported",
+			http.StatusInternalServerError)
+		return
+	}
+
+	offset := 0
+	lastID, err := strconv.Atoi(request.Header.Get("Last-Event-ID"))
+	if err == nil && lastID >= 0 {
+		offset = lastID + 1
+	}
+
+	headers := false
+	for {
+		server.mutex.Lock()
+		current, ok := server.runs[id]
+		if !ok {
+			server.mutex.Unlock()
+			if !headers {
+				http.NotFound(writer, request)
+			}
+
+			return
+		}
+
+		lines := []logLine{}
+		if offset < len(current.Log) {
+			lines = append(lines, current.Log[offset:]...)
+		}
+
+		finished := current.finished()
+		state := current.State
+		updates := current.updates()
+		server.mutex.Unlock()
+
+		if !headers {
+			writer.Header().Set("Content-Type", "text/event-stream")
+			writer.Header().Set("Cache-Control", "no-cache")
+			writer.WriteHeader(http.StatusOK)
+			headers = true
+		}
+
+		for _, line := range lines {
+			writeEvent(writer, offset, "log", line)
+			offset += 1
+		}
+
+		if finished {
+			writeEvent(writer, -1, "end", map[string]string{"state": state})
+			flusher.Flush()
+			return
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-updates:
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+func (server *server) cancel(id string) (int, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	current, ok := server.runs[id]
+	if !ok {
+		return http.StatusNotFound, errors.New("run " + id + " not found")
+	}
+
+	// running run is cancelled by its context and finished by execute after
+	// ensure sections are done
+	if current.State == runRunning && current.cancel != nil {
+		current.cancel()
+		return http.StatusAccepted, nil
+	}
+
+	if current.State != runQueued {
+		return http.StatusConflict, errors.New("run " + id + " is " +
+			current.State)
+	}
+
+	current.finish(runCancelled, exitInterrupted, errors.New("cancelled"))
+	server.save(current)
+	return http.StatusOK, nil
+}
+
+func (server *server) get(id string) (*run, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	current, ok := server.runs[id]
+	if !ok {
+		return nil, false
+	}
+
+	result := *current
+	result.Log = append([]logLine{}, current.Log...)
+	return &result, true
+}
+
+func (server *server) list() []*run {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	result := []*run{}
+	for _, current := range server.sorted() {
+		summary := *current
+		summary.Log = nil
+		result = append(result, &summary)
+	}
+
+	return result
+}
+
+// prune removes finished runs exceeding retention; mutex should be held
+func (server *server) prune() {
+	if server.retention == 0 {
+		return
+	}
+
+	sorted := server.sorted()
+	kept := 0
+	for index := len(sorted) - 1; index >= 0; index-- {
+		current := sorted[index]
+		if !current.finished() {
+			continue
+		}
+
+		kept += 1
+		if kept <= server.retention {
+			continue
+		}
+
+		delete(server.runs, current.ID)
+		err := server.store.remove(current.ID)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// sorted returns runs ordered by creation time; mutex should be held
+func (server *server) sorted() []*run {
+	result := make([]*run, 0, len(server.runs))
+	for _, current := range server.runs {
+		result = append(result, current)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+
+	return result
+}
+
+// save stores run on disk; mutex should be held
+func (server *server) save(current *run) {
+	err := server.store.save(current)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func allowMethod(
+	writer http.ResponseWriter,
+	request *http.Request,
+	method string,
+) bool {
+	if request.Method == method {
+		return true
+	}
+
+	writer.Header().Set("Allow", method)
+	http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// queryToArgv converts query to command line options; values are escaped, so
+// expressions in them are not expanded
+func queryToArgv(request *http.Request) []string {
+	query := request.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	argv := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			argv = append(argv, "--"+key, darius.Escape(value).(string))
+		}
+	}
+
+	return argv
+}
+
+func newRunID() (string, error) {
+	bytes := make([]byte, 8)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+func writeEvent(writer io.Writer, id int, event string, value interface{}) {
+	data, _ := json.Marshal(value)
+	if id >= 0 {
+		io.WriteString(writer, "id: "+strconv.Itoa(id)+"\n")
+	}
+
+	io.WriteString(writer, "event: "+event+"\ndata: "+string(data)+"\n\n")
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/idfly/darius"
+	"github.com/idfly/darius/jobs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	insecureTestConfig = "tasks: {T: {webhook: {insecure: true}, " +
+		"command: TASK}}"
+)
+
+func newTestServer(test *testing.T) (*server, *utilsMock) {
+	cli, utils := newTestCLI(true)
+	server := newServer(cli, ".darius.yml", test.TempDir())
+	return server, utils
+}
+
+func trigger(server *server, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", url, nil)
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func triggerID(test *testing.T, server *server, url string) string {
+	recorder := trigger(server, url)
+	assert.Equal(test, http.StatusAccepted, recorder.Code)
+	result := map[string]string{}
+	assert.NoError(test, json.Unmarshal(recorder.Body.Bytes(), &result))
+	return result["id"]
+}
+
+func runStarted(message string) bool {
+	return strings.Contains(message, "% run ")
+}
+
+func TestServeRunsTask(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(insecureTestConfig, nil)
+	utils.On("call", map[interface{}]interface{}{
+		"webhook": map[interface{}]interface{}{"insecure": true},
+		"command": "TASK",
+	}).Return(nil)
+	utils.On("out", "\x1b[1;37;42mtask completed\x1b[0m", true)
+	utils.On("out", mock.MatchedBy(runStarted), true)
+
+	recorder := trigger(server, "/tasks/T")
+	server.active.Wait()
+
+	assert.Equal(test, http.StatusAccepted, recorder.Code)
+	assert.Regexp(test, `^\{"id":"[0-9a-f]{16}","state":"queued"\}`,
+		recorder.Body.String())
+	utils.AssertExpectations(test)
+}
+
+func TestServeStoresRun(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(insecureTestConfig, nil)
+	utils.On("call", mock.Anything).Return(nil)
+	utils.On("out", mock.Anything, true)
+
+	id := triggerID(test, server, "/tasks/T")
+	server.active.Wait()
+
+	runs, err := server.store.load()
+	assert.NoError(test, err)
+	assert.Len(test, runs, 1)
+	assert.Equal(test, id, runs[0].ID)
+	assert.Equal(test, runSucceeded, runs[0].State)
+	assert.Equal(test, 0, runs[0].Status)
+	assert.Equal(test, logLine{1, 0, "run " + id + " of task T started"},
+		runs[0].Log[0])
+}
+
+func TestServeStoresFailedRun(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(insecureTestConfig, nil)
+	utils.On("call", mock.Anything).Return(assert.AnError)
+	utils.On("out", mock.Anything, true)
+
+	id := triggerID(test, server, "/tasks/T")
+	server.active.Wait()
+
+	current, ok := server.get(id)
+	assert.True(test, ok)
+	assert.Equal(test, runFailed, current.State)
+	assert.Equal(test, 1, current.Status)
+}
+
+func TestServeLimitsConcurrency(test *testing.T) {
+	server, utils := newTestServer(test)
+	release := make(chan bool)
+	utils.On("readFile", ".darius.yml").Return(
+		"tasks: {T: {concurrency: 1, webhook: {insecure: true}, "+
+			"command: TASK}}", nil)
+	utils.On("call", mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		<-release
+	})
+
+	utils.On("out", mock.Anything, true)
+
+	first := triggerID(test, server, "/tasks/T")
+	second := triggerID(test, server, "/tasks/T")
+
+	current, _ := server.get(first)
+	assert.Equal(test, runRunning, current.State)
+	current, _ = server.get(second)
+	assert.Equal(test, runQueued, current.State)
+
+	release <- true
+	release <- true
+	server.active.Wait()
+
+	current, _ = server.get(second)
+	assert.Equal(test, runSucceeded, current.State)
+}
+
+func TestServeCancelsQueuedRun(test *testing.T) {
+	server, _ := newTestServer(test)
+	server.runs["ID"] = &run{ID: "ID", State: runQueued, Created: time.Now()}
+
+	recorder := trigger(server, "/runs/ID/cancel")
+
+	assert.Equal(test, http.StatusOK, recorder.Code)
+	assert.Equal(test, runCancelled, server.runs["ID"].State)
+}
+
+func TestServeCancelsRunningRun(test *testing.T) {
+	server, utils := newTestServer(test)
+	started := make(chan bool)
+	release := make(chan bool)
+	utils.On("readFile", ".darius.yml").Return(insecureTestConfig, nil)
+	utils.On("call", mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		started <- true
+		<-release
+	})
+
+	utils.On("out", mock.Anything, true)
+
+	id := triggerID(test, server, "/tasks/T")
+	<-started
+	recorder := trigger(server, "/runs/"+id+"/cancel")
+	release <- true
+	server.active.Wait()
+
+	assert.Equal(test, http.StatusAccepted, recorder.Code)
+	assert.Contains(test, recorder.Body.String(), runCancelling)
+	current, _ := server.get(id)
+	assert.Equal(test, runCancelled, current.State)
+}
+
+func TestServeDoesNotCancelFinishedRun(test *testing.T) {
+	server, _ := newTestServer(test)
+	server.runs["ID"] = &run{ID: "ID", State: runSucceeded}
+
+	recorder := trigger(server, "/runs/ID/cancel")
+
+	assert.Equal(test, http.StatusConflict, recorder.Code)
+}
+
+func TestServeRestoreFailsInterruptedRuns(test *testing.T) {
+	server, _ := newTestServer(test)
+	server.store.save(&run{ID: "ID", State: runRunning})
+
+	assert.NoError(test, server.restore())
+
+	assert.Equal(test, runFailed, server.runs["ID"].State)
+	assert.Equal(test, "interrupted by server restart",
+		server.runs["ID"].Error)
+}
+
+func TestServePrunesOldRuns(test *testing.T) {
+	server, _ := newTestServer(test)
+	server.retention = 1
+	now := time.Now()
+	server.runs["OLD"] = &run{ID: "OLD", State: runFailed, Created: now}
+	server.runs["NEW"] = &run{ID: "NEW", State: runSucceeded,
+		Created: now.Add(time.Second)}
+
+	server.prune()
+
+	assert.Equal(test, []string{"NEW"}, runIDs(server.list()))
+}
+
+func TestServePrunesRestoredRuns(test *testing.T) {
+	server, _ := newTestServer(test)
+	server.retention = 1
+	now := time.Now()
+	server.store.save(&run{ID: "OLD", State: runFailed, Created: now})
+	server.store.save(&run{ID: "NEW", State: runSucceeded,
+		Created: now.Add(time.Second)})
+
+	assert.NoError(test, server.restore())
+
+	assert.Equal(test, []string{"NEW"}, runIDs(server.list()))
+	runs, err := server.store.load()
+	assert.NoError(test, err)
+	assert.Len(test, runs, 1)
+}
+
+func runIDs(runs []*run) []string {
+	result := []string{}
+	for _, current := range runs {
+		result = append(result, current.ID)
+	}
+
+	return result
+}
+
+func TestServePassesQueryAsArguments(test *testing.T) {
+	request := httptest.NewRequest("POST", "/tasks/T?b=$%7Bvars.x%7D&a=1",
+		nil)
+	argv := queryToArgv(request)
+	assert.Equal(test, []string{"--a", "1", "--b", "$${vars.x}"}, argv)
+}
+
+func TestServeRejectsTaskWithoutWebhook(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return("tasks: {T: TASK}", nil)
+
+	recorder := trigger(server, "/tasks/T")
+
+	assert.Equal(test, http.StatusForbidden, recorder.Code)
+}
+
+func TestServeQuotesArgumentsOfRun(test *testing.T) {
+	cli, utils := newTestCLI(false)
+	server := newServer(cli, ".darius.yml", test.TempDir())
+	utils.On("readFile", ".darius.yml").Return(
+		"tasks: {T: 'echo ${args.branch}'}", nil)
+	utils.On("out", mock.Anything, true)
+
+	id, err := server.submit("T", "", nil,
+		map[string]interface{}{"branch": "main; echo INJECTED"})
+	assert.NoError(test, err)
+	server.active.Wait()
+
+	current, _ := server.get(id)
+	assert.Equal(test, runSucceeded, current.State)
+	assert.Contains(test, current.Log,
+		logLine{darius.LogStdOut, 0, "main; echo INJECTED"})
+}
+
+func TestServeReportsUnknownTask(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(insecureTestConfig, nil)
+
+	recorder := trigger(server, "/tasks/UNKNOWN")
+
+	assert.Equal(test, http.StatusNotFound, recorder.Code)
+}
+
+func TestServeRejectsGet(test *testing.T) {
+	server, _ := newTestServer(test)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/tasks/T", nil)
+	server.ServeHTTP(recorder, request)
+
+	assert.Equal(test, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestServeStreamsLog(test *testing.T) {
+	server, _ := newTestServer(test)
+	server.runs["ID"] = &run{ID: "ID", State: runSucceeded, Log: []logLine{
+		{1, 0, "LINE1"},
+		{2, 1, "LINE2"},
+	}}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/runs/ID/log", nil)
+	server.ServeHTTP(recorder, request)
+
+	assert.Equal(test, "text/event-stream",
+		recorder.Header().Get("Content-Type"))
+	assert.Equal(test, "id: 0\nevent: log\n"+
+		`data: {"level":1,"indent":0,"message":"LINE1"}`+"\n\n"+
+		"id: 1\nevent: log\n"+
+		`data: {"level":2,"indent":1,"message":"LINE2"}`+"\n\n"+
+		"event: end\n"+`data: {"state":"succeeded"}`+"\n\n",
+		recorder.Body.String())
+}
+
+func TestServeStreamsLogFromLastEventID(test *testing.T) {
+	server, _ := newTestServer(test)
+	server.runs["ID"] = &run{ID: "ID", State: runSucceeded, Log: []logLine{
+		{1, 0, "LINE1"},
+		{2, 1, "LINE2"},
+	}}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/runs/ID/log", nil)
+	request.Header.Set("Last-Event-ID", "0")
+	server.ServeHTTP(recorder, request)
+
+	assert.NotContains(test, recorder.Body.String(), "LINE1")
+	assert.Contains(test, recorder.Body.String(), "LINE2")
+}
+
+func TestServeStreamsLiveLog(test *testing.T) {
+	server, _ := newTestServer(test)
+	current := &run{ID: "ID", State: runRunning}
+	server.runs["ID"] = current
+
+	done := make(chan bool)
+	recorder := httptest.NewRecorder()
+	go func() {
+		request := httptest.NewRequest("GET", "/runs/ID/log", nil)
+		server.ServeHTTP(recorder, request)
+		done <- true
+	}()
+
+	server.mutex.Lock()
+	current.append(logLine{2, 0, "LIVE"})
+	current.finish(runSucceeded, 0, nil)
+	server.mutex.Unlock()
+	<-done
+
+	assert.Contains(test, recorder.Body.String(), `"message":"LIVE"`)
+	assert.Contains(test, recorder.Body.String(), "event: end")
+}
+
+func TestServeReportsUnknownRunLog(test *testing.T) {
+	server, _ := newTestServer(test)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/runs/UNKNOWN/log", nil)
+	server.ServeHTTP(recorder, request)
+
+	assert.Equal(test, http.StatusNotFound, recorder.Code)
+}
+
+func TestServeDoesNotExpandExpressionsInArguments(test *testing.T) {
+	cli, utils := newTestCLI(false)
+	server := newServer(cli, ".darius.yml", test.TempDir())
+	file := filepath.Join(test.TempDir(), "injected")
+	utils.On("readFile", ".darius.yml").Return(
+		"tasks: {T: 'echo ${args.message | quote}'}", nil)
+	utils.On("out", mock.Anything, true)
+
+	message := "${shell touch " + file + "}"
+	id, err := server.submit("T", "", nil,
+		map[string]interface{}{"message": message})
+	assert.NoError(test, err)
+	server.active.Wait()
+
+	current, _ := server.get(id)
+	assert.Equal(test, runSucceeded, current.State)
+	assert.Contains(test, current.Log, logLine{darius.LogStdOut, 0, message})
+	assert.NoFileExists(test, file)
+}
+
+func TestServeStoresExitStatusOfFailedRun(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(insecureTestConfig, nil)
+	utils.On("call", mock.Anything).Return(&jobs.ExitError{Status: 3})
+	utils.On("out", mock.Anything, true)
+
+	id := triggerID(test, server, "/tasks/T")
+	server.active.Wait()
+
+	current, _ := server.get(id)
+	assert.Equal(test, 3, current.Status)
+	assert.NotNil(test, current.Started)
+	assert.NotNil(test, current.Finished)
+}
+
+func TestServeOmitsTimesOfQueuedRun(test *testing.T) {
+	contents, err := json.Marshal(&run{ID: "ID", State: runQueued})
+	assert.NoError(test, err)
+	assert.NotContains(test, string(contents), "started")
+	assert.NotContains(test, string(contents), "finished")
+}
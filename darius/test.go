@@ -1,32 +1,24 @@
 package main
 
 import (
-	"github.com/idfly/darius"
+	"context"
 
-	"github.com/shagabutdinov/shell"
+	"github.com/idfly/darius"
 )
 
-func newTestState(mockCall bool) (*state, *utilsMock) {
-	shell, err := shell.NewLocal(shell.LocalConfig{LineLimit: 1024})
-	if err != nil {
-		panic(err)
-	}
-
+func newTestCLI(mockCall bool) (*cli, *utilsMock) {
 	utils := &utilsMock{}
-	state := &state{
-		jobs: map[string]func(darius.State, map[interface{}]interface{}) error{
+	cli := &cli{
+		ctx: context.Background(),
+		jobs: map[string]darius.Job{
 			"call": utils.call,
 		},
-		shell:  shell,
-		utils:  utils,
-		parent: nil,
+		utils: utils,
 	}
 
 	if !mockCall {
-		state.jobs = jobsFuncs
+		cli.jobs = newCLI().jobs
 	}
 
-	state.expression = darius.NewExpression(state, state.expandExpression)
-
-	return state, utils
+	return cli, utils
 }
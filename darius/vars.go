@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/idfly/darius"
+	"github.com/shagabutdinov/arguments"
+	"gopkg.in/yaml.v2"
+)
+
+// parseVars reads overrides of vars from --vars-file and then from --var
+// options; dotted keys set nested vars
+func parseVars(
+	cli *cli,
+	values arguments.Values,
+) (map[interface{}]interface{}, error) {
+	result := map[interface{}]interface{}{}
+	file, found, err := values.String("vars-file", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		data, err := cli.utils.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		err = yaml.Unmarshal(data, &result)
+		if err != nil {
+			return nil, errors.New("failed to parse " + file + ": " +
+				err.Error())
+		}
+	}
+
+	vars, _, err := values.Strings("var", []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, raw := range vars {
+		parts := strings.SplitN(raw, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("var should be set as key=value: " + raw)
+		}
+
+		var value interface{} = parts[1]
+		path := strings.Split(parts[0], ".")
+		for index := len(path) - 1; index >= 0; index-- {
+			value = map[interface{}]interface{}{path[index]: value}
+		}
+
+		result = darius.Merge(result, value.(map[interface{}]interface{}))
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	return result, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/idfly/darius"
+)
+
+const (
+	webhookGithub  = "github"
+	webhookGitlab  = "gitlab"
+	webhookGeneric = "generic"
+
+	webhookGenericHeader = "X-Darius-Signature"
+)
+
+var (
+	errWebhookSignature = errors.New("webhook signature mismatch")
+
+	webhookPayloadPaths = map[string][][]string{
+		"branch": {
+			{"ref"},
+			{"branch"},
+		},
+		"commit": {
+			{"after"},
+			{"checkout_sha"},
+			{"commit"},
+		},
+		"pusher": {
+			{"pusher", "name"},
+			{"user_username"},
+			{"user_name"},
+			{"pusher"},
+		},
+		"repository": {
+			{"repository", "full_name"},
+			{"project", "path_with_namespace"},
+			{"repository"},
+		},
+	}
+)
+
+type webhook struct {
+	secret   string
+	provider string
+	header   string
+	insecure bool
+}
+
+func newWebhook(runner *darius.Runner, task interface{}) (*webhook, error) {
+	mapping, ok := task.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	raw, ok := mapping["webhook"]
+	if !ok {
+		return nil, nil
+	}
+
+	expanded, err := runner.Expand(raw, true)
+	if err != nil {
+		return nil, err
+	}
+
+	config, ok := expanded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webhook should be map")
+	}
+
+	result := &webhook{header: webhookGenericHeader}
+	fields := map[string]*string{
+		"secret":   &result.secret,
+		"provider": &result.provider,
+		"header":   &result.header,
+	}
+
+	for key, target := range fields {
+		value, ok := config[key]
+		if !ok {
+			continue
+		}
+
+		*target, ok = value.(string)
+		if !ok {
+			return nil, errors.New("webhook " + key + " should be string")
+		}
+	}
+
+	insecure, ok := config["insecure"]
+	if ok {
+		result.insecure, ok = insecure.(bool)
+		if !ok {
+			return nil, errors.New("webhook insecure should be boolean")
+		}
+	}
+
+	if result.secret == "" && !result.insecure {
+		return nil, errors.New("webhook secret must be set")
+	}
+
+	switch result.provider {
+	case "", webhookGithub, webhookGitlab, webhookGeneric:
+	default:
+		return nil, errors.New("unknown webhook provider " + result.provider)
+	}
+
+	return result, nil
+}
+
+func (webhook *webhook) detect(header http.Header) string {
+	if webhook.provider != "" {
+		return webhook.provider
+	}
+
+	if header.Get("X-Hub-Signature-256") != "" {
+		return webhookGithub
+	}
+
+	if header.Get("X-Gitlab-Token") != "" {
+		return webhookGitlab
+	}
+
+	return webhookGeneric
+}
+
+func (webhook *webhook) verify(header http.Header, body []byte) error {
+	switch webhook.detect(header) {
+	case webhookGithub:
+		return webhook.verifySignature(header.Get("X-Hub-Signature-256"), body)
+	case webhookGitlab:
+		token := []byte(header.Get("X-Gitlab-Token"))
+		if subtle.ConstantTimeCompare(token, []byte(webhook.secret)) != 1 {
+			return errWebhookSignature
+		}
+
+		return nil
+	default:
+		return webhook.verifySignature(header.Get(webhook.header), body)
+	}
+}
+
+func (webhook *webhook) verifySignature(signature string, body []byte) error {
+	signature = strings.TrimPrefix(signature, "sha256=")
+	received, err := hex.DecodeString(signature)
+	if err != nil || len(received) == 0 {
+		return errWebhookSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(webhook.secret))
+	mac.Write(body)
+	if !hmac.Equal(received, mac.Sum(nil)) {
+		return errWebhookSignature
+	}
+
+	return nil
+}
+
+func parseWebhookPayload(
+	provider string,
+	body []byte,
+) map[interface{}]interface{} {
+	result := map[interface{}]interface{}{}
+	if provider != "" {
+		result["provider"] = provider
+	}
+
+	var payload map[string]interface{}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return result
+	}
+
+	for name, paths := range webhookPayloadPaths {
+		for _, path := range paths {
+			value, ok := lookupPayload(payload, path)
+			if ok {
+				result[name] = value
+				break
+			}
+		}
+	}
+
+	ref, ok := result["branch"].(string)
+	if ok {
+		if strings.HasPrefix(ref, "refs/tags/") {
+			delete(result, "branch")
+			result["tag"] = strings.TrimPrefix(ref, "refs/tags/")
+		} else {
+			result["branch"] = strings.TrimPrefix(ref, "refs/heads/")
+		}
+	}
+
+	return result
+}
+
+func lookupPayload(payload map[string]interface{}, path []string) (
+	string,
+	bool,
+) {
+	var current interface{} = payload
+	for _, key := range path {
+		mapping, ok := current.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+
+		current, ok = mapping[key]
+		if !ok {
+			return "", false
+		}
+	}
+
+	str, ok := current.(string)
+	if !ok || str == "" {
+		return "", false
+	}
+
+	return str, true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	webhookTestBody = `{"ref": "refs/heads/main", "after": "SHA",
+		"pusher": {"name": "PUSHER"}, "repository": {"full_name": "REPO"}}`
+
+	// hmac-sha256 of webhookTestBody with "SECRET" key
+	webhookTestSignature = "sha256=" +
+		"bfceba1dfd0e2589f176839b19534dc634156a2f726eccb45853bf18bf822aec"
+)
+
+func newTestWebhook(provider string) *webhook {
+	return &webhook{
+		secret:   "SECRET",
+		provider: provider,
+		header:   webhookGenericHeader,
+	}
+}
+
+func TestWebhookVerifiesGithubSignature(test *testing.T) {
+	header := http.Header{}
+	header.Set("X-Hub-Signature-256", webhookTestSignature)
+	err := newTestWebhook("").verify(header, []byte(webhookTestBody))
+	assert.NoError(test, err)
+}
+
+func TestWebhookRejectsWrongGithubSignature(test *testing.T) {
+	header := http.Header{}
+	header.Set("X-Hub-Signature-256", webhookTestSignature)
+	err := newTestWebhook("").verify(header, []byte(webhookTestBody+" "))
+	assert.Equal(test, errWebhookSignature, err)
+}
+
+func TestWebhookVerifiesGitlabToken(test *testing.T) {
+	header := http.Header{}
+	header.Set("X-Gitlab-Token", "SECRET")
+	err := newTestWebhook("").verify(header, []byte(webhookTestBody))
+	assert.NoError(test, err)
+}
+
+func TestWebhookRejectsWrongGitlabToken(test *testing.T) {
+	header := http.Header{}
+	header.Set("X-Gitlab-Token", "WRONG")
+	err := newTestWebhook("").verify(header, []byte(webhookTestBody))
+	assert.Equal(test, errWebhookSignature, err)
+}
+
+func TestWebhookVerifiesGenericSignature(test *testing.T) {
+	header := http.Header{}
+	header.Set(webhookGenericHeader, webhookTestSignature)
+	err := newTestWebhook("").verify(header, []byte(webhookTestBody))
+	assert.NoError(test, err)
+}
+
+func TestWebhookRejectsMissingSignature(test *testing.T) {
+	err := newTestWebhook("").verify(http.Header{}, []byte(webhookTestBody))
+	assert.Equal(test, errWebhookSignature, err)
+}
+
+func TestWebhookUsesConfiguredProvider(test *testing.T) {
+	header := http.Header{}
+	header.Set("X-Gitlab-Token", "SECRET")
+	err := newTestWebhook(webhookGithub).verify(header,
+		[]byte(webhookTestBody))
+	assert.Equal(test, errWebhookSignature, err)
+}
+
+func TestWebhookParsesGithubPayload(test *testing.T) {
+	result := parseWebhookPayload(webhookGithub, []byte(webhookTestBody))
+	assert.Equal(test, map[interface{}]interface{}{
+		"provider":   "github",
+		"branch":     "main",
+		"commit":     "SHA",
+		"pusher":     "PUSHER",
+		"repository": "REPO",
+	}, result)
+}
+
+func TestWebhookParsesGitlabPayload(test *testing.T) {
+	body := `{"ref": "refs/tags/v1", "checkout_sha": "SHA",
+		"user_username": "PUSHER",
+		"project": {"path_with_namespace": "REPO"}}`
+
+	result := parseWebhookPayload(webhookGitlab, []byte(body))
+	assert.Equal(test, map[interface{}]interface{}{
+		"provider":   "gitlab",
+		"tag":        "v1",
+		"commit":     "SHA",
+		"pusher":     "PUSHER",
+		"repository": "REPO",
+	}, result)
+}
+
+func TestServeRejectsUnsignedWebhook(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(
+		"tasks: {T: {webhook: {secret: SECRET}, command: TASK}}", nil)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/tasks/T",
+		strings.NewReader(webhookTestBody))
+	server.ServeHTTP(recorder, request)
+
+	assert.Equal(test, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestServePassesPayloadAsArgumentsWithoutQuery(test *testing.T) {
+	server, utils := newTestServer(test)
+	utils.On("readFile", ".darius.yml").Return(
+		"tasks: {T: {webhook: {secret: SECRET}, command: TASK}}", nil)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/tasks/T?branch=other",
+		strings.NewReader(webhookTestBody))
+	request.Header.Set("X-Hub-Signature-256", webhookTestSignature)
+	task, argv, args, _, err := server.prepare("T", recorder, request)
+
+	assert.NoError(test, err)
+	assert.NotNil(test, task)
+	assert.Empty(test, argv)
+	assert.Equal(test, "main", args["branch"])
+	assert.Equal(test, "github", args["provider"])
+}
@@ -14,6 +14,10 @@ type Expression struct {
 	stack []string
 }
 
+// Untrusted is string received from outside of configuration, e.g. from
+// webhook payload; it is shell-quoted when it is expanded inside of string
+type Untrusted string
+
 var (
 	expandRegexp = regexp.MustCompile(`\$+\{.*?\}`)
 )
@@ -37,12 +41,14 @@ func (expander *Expression) Expand(
 
 	expr := expandRegexp.FindString(str)
 	if expr == str {
-		result, err := expander.expand(expr)
+		result, nested, err := expander.expand(expr)
 		if err != nil {
 			return nil, err
 		}
 
-		if recursive {
+		// values of expressions are never expanded again; only maps and
+		// arrays from configuration have nested values to expand
+		if recursive && nested {
 			return expander.expandRecursive(result)
 		}
 
@@ -51,13 +57,13 @@ func (expander *Expression) Expand(
 
 	var errs []string
 	result := expandRegexp.ReplaceAllStringFunc(str, func(expr string) string {
-		result, err := expander.expand(expr)
+		result, _, err := expander.expand(expr)
 		if err != nil {
 			errs = append(errs, err.Error())
 			return expr
 		}
 
-		return fmt.Sprint(result)
+		return embed(result)
 	})
 
 	if len(errs) > 0 {
@@ -101,54 +107,118 @@ func (expander *Expression) expandRecursive(
 	return value, nil
 }
 
-func (expander *Expression) expand(expr string) (interface{}, error) {
+// expand returns value of expression and whether it is map or array from
+// configuration which nested values are not expanded yet
+func (expander *Expression) expand(
+	expr string,
+) (interface{}, bool, error) {
 	prefix := regexp.MustCompile(`^\$+`).FindString(expr)
 	truncatedPrefix := strings.Repeat("$", len(prefix)/2)
 	if len(prefix)%2 == 0 {
-		return truncatedPrefix + expr[len(prefix):], nil
+		return truncatedPrefix + expr[len(prefix):], false, nil
 	}
 
 	for _, value := range expander.stack {
 		if value == expr {
-			return nil, errors.New("recursive expression detected: " +
+			return nil, false, errors.New("recursive expression detected: " +
 				strings.Join(append(expander.stack, expr), " -> "))
 		}
 	}
 
 	expander.stack = append(expander.stack, expr)
+	defer func() {
+		expander.stack = expander.stack[:len(expander.stack)-1]
+	}()
 
 	expr = strings.TrimLeft(expr, "${")
 	expr = expr[:len(expr)-1]
 
+	expr, calls, err := parsePipeline(expr)
+	if err != nil {
+		return nil, false, err
+	}
+
 	parts := regexp.MustCompile(`^(\w+)(?:\W(.*?)$|$)`).FindStringSubmatch(expr)
 	if len(parts) == 0 {
-		return expr, errors.New("wrong expression: " + expr)
+		return expr, false, errors.New("wrong expression: " + expr)
 	}
 
-	var err error
 	var result interface{}
 	if parts[1] == "vars" {
 		result, err = expander.expandVariable(strings.Split(parts[2], "."))
 	} else if parts[1] == "args" {
 		result, err = expander.expandArguments(strings.Split(parts[2], "."))
+	} else if parts[1] == "steps" {
+		result, err = expander.expandStep(strings.Split(parts[2], "."))
 	} else {
 		result, err = expander.ExpandExpression(parts[1], parts[2])
 	}
 
+	var undefined *undefinedError
+	if errors.As(err, &undefined) && hasDefault(calls) {
+		result, err = nil, nil
+	}
+
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	result, err = applyFilters(result, calls)
+	if err != nil {
+		return nil, false, err
 	}
 
 	if truncatedPrefix != "" {
-		return fmt.Sprint(truncatedPrefix, result), nil
+		return truncatedPrefix + embed(result), false, nil
 	}
 
-	return result, nil
+	nested := false
+	if parts[1] == "vars" && len(calls) == 0 {
+		switch result.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			nested = true
+		}
+	}
 
+	return result, nested, nil
 }
 
 func (expander *Expression) expandVariable(expr []string) (interface{}, error) {
-	current := expander.State
+	result, found, err := LookupVariable(expander.State, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, &undefinedError{name: "vars." + strings.Join(expr, ".")}
+	}
+
+	return result, nil
+}
+
+// overrider is implemented by states which vars are overridden by runner
+type overrider interface {
+	varOverrides() map[interface{}]interface{}
+}
+
+// LookupVariable finds variable in vars overridden by runner, in vars of task,
+// its parents and then in vars of configuration; overridden maps are merged
+// into vars of configuration, so only other values are taken from overrides
+func LookupVariable(state State, expr []string) (interface{}, bool, error) {
+	overrider, ok := state.(overrider)
+	if ok && overrider.varOverrides() != nil {
+		result, found, err := ExpandMap(state, overrider.varOverrides(), expr)
+		if err != nil {
+			return nil, false, err
+		}
+
+		_, isMap := result.(map[interface{}]interface{})
+		if found && !isMap {
+			return result, true, nil
+		}
+	}
+
+	current := state
 	for current != nil {
 		task := current.Task()
 		if task != nil {
@@ -157,7 +227,7 @@ func (expander *Expression) expandVariable(expr []string) (interface{}, error) {
 				result, found, err := ExpandMap(current, taskVars, expr)
 
 				if found || err != nil {
-					return result, err
+					return result, found, err
 				}
 			}
 		}
@@ -169,17 +239,16 @@ func (expander *Expression) expandVariable(expr []string) (interface{}, error) {
 		}
 	}
 
-	config, ok := expander.State.Config()["vars"]
+	config, ok := state.Config()["vars"]
 	if ok {
-		result, found, err := ExpandMap(expander.State, config, expr)
-		if found || err != nil {
-			return result, err
-		}
+		return ExpandMap(state, config, expr)
 	}
 
-	return nil, errors.New("undefined variable vars." + strings.Join(expr, "."))
+	return nil, false, nil
 }
 
+// expandArguments reads argument; values from webhook payload are untrusted,
+// so they are not expanded
 func (expander *Expression) expandArguments(expr []string) (interface{}, error) {
 	result, found, err := ExpandMap(expander.State, expander.State.Args(), expr)
 	if err != nil {
@@ -187,13 +256,83 @@ func (expander *Expression) expandArguments(expr []string) (interface{}, error)
 	}
 
 	if !found {
-		return nil, errors.New("undefined variable args." +
-			strings.Join(expr, "."))
+		return nil, &undefinedError{name: "args." + strings.Join(expr, ".")}
 	}
 
 	return result, nil
 }
 
+// expandStep reads output of step; captured output is not expanded as it could
+// contain anything; in dry run steps are not run, so expression is kept
+func (expander *Expression) expandStep(expr []string) (interface{}, error) {
+	name := "steps." + strings.Join(expr, ".")
+	step, ok := expander.State.Step(expr[0])
+	if !ok && expander.State.DryRun() {
+		return "${" + name + "}", nil
+	}
+
+	if !ok {
+		return nil, &undefinedError{name: name}
+	}
+
+	result, found, err := lookupMap(step, expr[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, &undefinedError{name: name}
+	}
+
+	return result, nil
+}
+
+// lookupMap finds value by path in map without expanding it
+func lookupMap(value interface{}, expr []string) (interface{}, bool, error) {
+	current := value
+	for _, key := range expr {
+		mapping, ok := current.(map[interface{}]interface{})
+		if !ok {
+			return nil, false, errors.New("variable should be map")
+		}
+
+		current, ok = mapping[key]
+		if !ok {
+			return nil, false, nil
+		}
+	}
+
+	return current, true, nil
+}
+
+// Escape doubles dollar signs of expressions in value, so expansion of
+// result returns value as is; it is used in order to pass data to tasks
+func Escape(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return expandRegexp.ReplaceAllStringFunc(value, func(expr string) string {
+			prefix := regexp.MustCompile(`^\$+`).FindString(expr)
+			return prefix + expr
+		})
+	case map[interface{}]interface{}:
+		result := map[interface{}]interface{}{}
+		for key, element := range value {
+			result[key] = Escape(element)
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for index, element := range value {
+			result[index] = Escape(element)
+		}
+
+		return result
+	}
+
+	return value
+}
+
 func ExpandMap(
 	state State,
 	value interface{},
@@ -229,3 +368,13 @@ func ExpandMap(
 
 	return current, true, nil
 }
+
+// embed returns value of expression to put inside of string
+func embed(value interface{}) string {
+	untrusted, ok := value.(Untrusted)
+	if ok {
+		return quote(string(untrusted))
+	}
+
+	return fmt.Sprint(value)
+}
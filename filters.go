@@ -0,0 +1,286 @@
+package darius
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type filter struct {
+	arguments int
+	apply     func(value interface{}, arguments []string) (interface{}, error)
+}
+
+type filterCall struct {
+	name      string
+	arguments []string
+}
+
+type undefinedError struct {
+	name    string
+	message string
+}
+
+func (err *undefinedError) Error() string {
+	if err.message != "" {
+		return err.message
+	}
+
+	return "undefined variable " + err.name
+}
+
+var (
+	filters = map[string]filter{
+		"lower":   {0, stringFilter(strings.ToLower)},
+		"upper":   {0, stringFilter(strings.ToUpper)},
+		"trim":    {0, stringFilter(strings.TrimSpace)},
+		"quote":   {0, stringFilter(quote)},
+		"base64":  {0, stringFilter(encodeBase64)},
+		"sha256":  {0, stringFilter(encodeSHA256)},
+		"replace": {2, replaceFilter},
+		"join":    {1, joinFilter},
+		"default": {1, defaultFilter},
+		"json":    {0, jsonFilter},
+		"yaml":    {0, yamlFilter},
+	}
+)
+
+// parsePipeline splits expression into its head and filters; filters are
+// taken from the end of expression while they are known, so pipes in
+// `${shell ...}` commands are kept intact
+func parsePipeline(expr string) (string, []filterCall, error) {
+	segments := splitPipeline(expr)
+	calls := []filterCall{}
+	for len(segments) > 1 {
+		words, err := splitWords(segments[len(segments)-1])
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(words) == 0 {
+			break
+		}
+
+		current, ok := filters[words[0]]
+		if !ok {
+			break
+		}
+
+		if len(words)-1 != current.arguments {
+			return "", nil, errors.New("filter " + words[0] + " expects " +
+				strconv.Itoa(current.arguments) + " arguments, got " +
+				strconv.Itoa(len(words)-1))
+		}
+
+		calls = append([]filterCall{{words[0], words[1:]}}, calls...)
+		segments = segments[:len(segments)-1]
+	}
+
+	return strings.TrimSpace(strings.Join(segments, "|")), calls, nil
+}
+
+func splitPipeline(expr string) []string {
+	result := []string{}
+	quote := rune(0)
+	start := 0
+	escaped := false
+	for index, char := range expr {
+		if escaped {
+			escaped = false
+		} else if char == '\\' && quote == '"' {
+			escaped = true
+		} else if quote != 0 {
+			if char == quote {
+				quote = 0
+			}
+		} else if char == '"' || char == '\'' {
+			quote = char
+		} else if char == '|' {
+			result = append(result, expr[start:index])
+			start = index + 1
+		}
+	}
+
+	return append(result, expr[start:])
+}
+
+func splitWords(segment string) ([]string, error) {
+	result := []string{}
+	segment = strings.TrimSpace(segment)
+	for segment != "" {
+		if segment[0] == '\'' {
+			end := strings.Index(segment[1:], "'")
+			if end == -1 {
+				return nil, errors.New("wrong filter arguments: " + segment)
+			}
+
+			result = append(result, segment[1:end+1])
+			segment = strings.TrimSpace(segment[end+2:])
+			continue
+		}
+
+		if segment[0] != '"' {
+			end := strings.IndexAny(segment, " \t")
+			if end == -1 {
+				end = len(segment)
+			}
+
+			result = append(result, segment[:end])
+			segment = strings.TrimSpace(segment[end:])
+			continue
+		}
+
+		prefix, err := strconv.QuotedPrefix(segment)
+		if err != nil {
+			return nil, errors.New("wrong filter arguments: " + segment)
+		}
+
+		word, err := strconv.Unquote(prefix)
+		if err != nil {
+			return nil, errors.New("wrong filter arguments: " + segment)
+		}
+
+		result = append(result, word)
+		segment = strings.TrimSpace(segment[len(prefix):])
+	}
+
+	return result, nil
+}
+
+// applyFilters applies calls to value; untrusted value stays untrusted until
+// it is quoted
+func applyFilters(value interface{}, calls []filterCall) (interface{}, error) {
+	for _, call := range calls {
+		_, untrusted := value.(Untrusted)
+		var err error
+		value, err = filters[call.name].apply(value, call.arguments)
+		if err != nil {
+			return nil, errors.New("filter " + call.name + " failed: " +
+				err.Error())
+		}
+
+		str, ok := value.(string)
+		if untrusted && ok && call.name != "quote" {
+			value = Untrusted(str)
+		}
+	}
+
+	return value, nil
+}
+
+func hasDefault(calls []filterCall) bool {
+	for _, call := range calls {
+		if call.name == "default" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stringFilter(
+	apply func(string) string,
+) func(interface{}, []string) (interface{}, error) {
+	return func(value interface{}, _ []string) (interface{}, error) {
+		return apply(stringify(value)), nil
+	}
+}
+
+func stringify(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// quote escapes value for shell by wrapping it into single quotes
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func encodeBase64(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func encodeSHA256(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func replaceFilter(value interface{}, arguments []string) (interface{}, error) {
+	return strings.ReplaceAll(stringify(value), arguments[0], arguments[1]), nil
+}
+
+func joinFilter(value interface{}, arguments []string) (interface{}, error) {
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("value should be array")
+	}
+
+	result := make([]string, len(array))
+	for index, element := range array {
+		result[index] = stringify(element)
+	}
+
+	return strings.Join(result, arguments[0]), nil
+}
+
+func defaultFilter(value interface{}, arguments []string) (interface{}, error) {
+	if value == nil || value == "" || value == Untrusted("") {
+		return arguments[0], nil
+	}
+
+	return value, nil
+}
+
+func jsonFilter(value interface{}, _ []string) (interface{}, error) {
+	result, err := json.Marshal(jsonValue(value))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(result), nil
+}
+
+// jsonValue converts yaml maps into maps with string keys which are supported
+// by json encoder
+func jsonValue(value interface{}) interface{} {
+	mapping, ok := value.(map[interface{}]interface{})
+	if ok {
+		result := map[string]interface{}{}
+		for key, value := range mapping {
+			result[fmt.Sprint(key)] = jsonValue(value)
+		}
+
+		return result
+	}
+
+	array, ok := value.([]interface{})
+	if ok {
+		result := make([]interface{}, len(array))
+		for index, value := range array {
+			result[index] = jsonValue(value)
+		}
+
+		return result
+	}
+
+	return value
+}
+
+func yamlFilter(value interface{}, _ []string) (interface{}, error) {
+	result, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.TrimRight(string(result), "\n"), nil
+}
@@ -1,16 +1,30 @@
 package jobs
 
 import (
+	"context"
 	"errors"
 	"log"
+	"time"
 
 	"github.com/idfly/darius"
 
 	"github.com/shagabutdinov/shell"
 )
 
+// GracePeriod limits time given to ensure sections of interrupted run
+var GracePeriod = 30 * time.Second
+
 func Call(state darius.State, task map[interface{}]interface{}) error {
+	_, isMatrix := task["matrix"]
+	if isMatrix {
+		return runMatrix(state, task)
+	}
+
 	newState, err := state.Spawn(task)
+	if err == darius.ErrSkipped {
+		return nil
+	}
+
 	if err != nil {
 		state.Log(darius.LogCommandFail, err.Error())
 		return err
@@ -25,24 +39,80 @@ func Call(state darius.State, task map[interface{}]interface{}) error {
 		}
 	}()
 
-	ok, err := runCheckContext(newState, task)
+	timeout, err := parseTimeout(newState, task)
+	if err != nil {
+		newState.Log(darius.LogCommandFail, err.Error())
+		return err
+	}
+
+	retry, err := parseRetry(newState, task)
+	if err != nil {
+		newState.Log(darius.LogCommandFail, err.Error())
+		return err
+	}
+
+	fingerprint, err := newFingerprint(newState, task)
+	if err == nil && fingerprint != nil {
+		var fresh bool
+		fresh, err = fingerprint.fresh()
+		if err == nil && fresh {
+			newState.Log(darius.LogSystem, "sources did not change; skipped")
+			return runTail(newState, task, nil)
+		}
+	}
+
+	if err != nil {
+		newState.Log(darius.LogCommandFail, err.Error())
+		return err
+	}
+
+	// rescue and ensure are run with state of task in order to not be
+	// limited by its timeout
+	runState := newState
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(newState.Context(), timeout)
+		defer cancel()
+		runState = newState.WithContext(ctx)
+	}
+
+	ok, err := true, runState.Context().Err()
+	if err == nil {
+		ok, err = runCheckContext(runState, task)
+	}
+
 	if err == nil {
 		if !ok {
 			return nil
 		}
 
 		var report bool
-		report, err = runMapping(newState, task)
+		report, err = runRetry(runState, task, retry)
 		if err != nil && report {
 			newState.Log(darius.LogCommandFail, err.Error())
 		}
 	}
 
+	if err != nil && newState.Context().Err() == nil &&
+		runState.Context().Err() == context.DeadlineExceeded {
+		err = errors.New("timeout " + timeout.String() + " exceeded")
+		newState.Log(darius.LogCommandFail, err.Error())
+	}
+
+	// fingerprint is saved only if task itself succeeded, so failure handled
+	// by rescue is retried on next run
+	bodyErr := err
 	err = runTail(newState, task, err)
 	if err != nil {
 		return err
 	}
 
+	if fingerprint != nil && bodyErr == nil && !newState.DryRun() {
+		err = fingerprint.save()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
 	return nil
 }
 
@@ -73,13 +143,50 @@ func runCheckContext(
 	)
 
 	if err != nil {
-		state.Log(darius.LogCommandFail, err.Error())
+		if err != state.Context().Err() {
+			state.Log(darius.LogCommandFail, err.Error())
+		}
+
 		return false, err
 	}
 
 	return status == 0, nil
 }
 
+// parseTimeout reads timeout of task; if task has no timeout then timeout is
+// taken from vars
+func parseTimeout(
+	state darius.State,
+	task map[interface{}]interface{},
+) (time.Duration, error) {
+	raw, ok := task["timeout"]
+	if !ok {
+		var err error
+		raw, ok, err = darius.LookupVariable(state, []string{"timeout"})
+		if err != nil || !ok {
+			return 0, err
+		}
+	}
+
+	raw, err := state.Expand(raw, false)
+	if err != nil {
+		return 0, err
+	}
+
+	str, ok := raw.(string)
+	if !ok {
+		return 0, errors.New("timeout should be string")
+	}
+
+	timeout, err := time.ParseDuration(str)
+	if err != nil || timeout <= 0 {
+		return 0, errors.New("timeout should be positive duration like " +
+			"\"30s\" or \"5m\": " + str)
+	}
+
+	return timeout, nil
+}
+
 func runMapping(
 	state darius.State,
 	task map[interface{}]interface{},
@@ -93,10 +200,11 @@ func runMapping(
 
 	raw, ok := task["job"]
 	if !ok {
-		_, ok := task["command"]
-		if !ok {
-			return true, errors.New("\"job\" or \"command\" should be " +
-				"defined in task")
+		_, hasCommand := task["command"]
+		_, hasScript := task["script"]
+		if !hasCommand && !hasScript {
+			return true, errors.New("\"job\", \"command\" or \"script\" " +
+				"should be defined in task")
 		}
 
 		return false, state.Call("execute", task)
@@ -119,18 +227,26 @@ func runTail(
 	task map[interface{}]interface{},
 	err error,
 ) error {
-	if err != nil {
+	// task was interrupted; rescue is skipped while ensure still gets a
+	// chance to clean up within grace period
+	if state.Context().Err() != nil {
+		ctx, cancel := darius.GraceContext(state.Context(), GracePeriod)
+		defer cancel()
+		state = state.WithContext(ctx)
+	} else if err != nil || state.DryRun() {
 		rescue, ok := task["rescue"]
 		if ok {
 			state.Log(darius.LogRescue, "[rescue]")
-			err = state.Call("call", darius.CreateTask(rescue))
+			subtask := inheritInterpreter(task, darius.CreateTask(rescue))
+			err = state.Call("call", subtask)
 		}
 	}
 
 	ensure, ok := task["ensure"]
 	if ok {
 		state.Log(darius.LogEnsure, "[ensure]")
-		ensureErr := state.Call("call", darius.CreateTask(ensure))
+		subtask := inheritInterpreter(task, darius.CreateTask(ensure))
+		ensureErr := state.Call("call", subtask)
 		if ensureErr != nil {
 			err = ensureErr
 		}
@@ -1,12 +1,15 @@
 package jobs
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/idfly/darius"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestCallRunsString(test *testing.T) {
@@ -29,8 +32,8 @@ func TestCallReturnsStringError(test *testing.T) {
 
 func TestCallReturnsErrorOnUnknownTaskType(test *testing.T) {
 	state := newState()
-	state.On("Log", darius.LogCommandFail, "\"job\" or \"command\" should be "+
-		"defined in task")
+	state.On("Log", darius.LogCommandFail, "\"job\", \"command\" or "+
+		"\"script\" should be defined in task")
 	err := Call(state, map[interface{}]interface{}{})
 	assert.Error(test, err)
 }
@@ -150,3 +153,74 @@ func TestCallCallsEnsureAfterError(test *testing.T) {
 	assert.Error(test, err, "ERROR2")
 	state.AssertExpectations(test)
 }
+
+func TestCallRunsOnlyEnsureIfContextCancelled(test *testing.T) {
+	state := newState()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	state.ctx = ctx
+	command := map[interface{}]interface{}{"command": "CMD",
+		"rescue": "RESCUE", "ensure": "ENSURE"}
+	state.On("Call", "call", map[interface{}]interface{}{"command": "ENSURE"}).
+		Return(nil)
+	state.On("Log", darius.LogEnsure, "[ensure]").Return(nil)
+	err := Call(state, command)
+	assert.Equal(test, context.Canceled, err)
+	state.AssertExpectations(test)
+}
+
+func TestCallRunsRescueAndEnsureAfterTimeout(test *testing.T) {
+	state := newState()
+	command := map[interface{}]interface{}{"command": "CMD", "timeout": "1ms",
+		"rescue": "RESCUE", "ensure": "ENSURE"}
+	state.On("Call", "execute", command).Return(context.DeadlineExceeded).
+		Run(func(mock.Arguments) { time.Sleep(10 * time.Millisecond) })
+	state.On("Log", darius.LogCommandFail, "timeout 1ms exceeded")
+	state.On("Call", "call", map[interface{}]interface{}{"command": "RESCUE"}).
+		Return(nil)
+	state.On("Log", darius.LogRescue, "[rescue]")
+	state.On("Call", "call", map[interface{}]interface{}{"command": "ENSURE"}).
+		Return(nil)
+	state.On("Log", darius.LogEnsure, "[ensure]")
+	err := Call(state, command)
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestCallTakesTimeoutFromVars(test *testing.T) {
+	state := newState()
+	command := map[interface{}]interface{}{"command": "CMD",
+		"vars": map[interface{}]interface{}{"timeout": "1ms"}}
+	state.On("Call", "execute", command).Return(context.DeadlineExceeded).
+		Run(func(mock.Arguments) { time.Sleep(10 * time.Millisecond) })
+	state.On("Log", darius.LogCommandFail, "timeout 1ms exceeded")
+	err := Call(state, command)
+	assert.EqualError(test, err, "timeout 1ms exceeded")
+	state.AssertExpectations(test)
+}
+
+func TestCallTakesTimeoutFromVarsOfConfiguration(test *testing.T) {
+	state := &state{}
+	state.On("Parent").Return(nil)
+	state.On("Config").Return(map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"timeout": "1ms"},
+	})
+
+	command := map[interface{}]interface{}{"command": "CMD"}
+	state.On("Call", "execute", command).Return(context.DeadlineExceeded).
+		Run(func(mock.Arguments) { time.Sleep(10 * time.Millisecond) })
+	state.On("Log", darius.LogCommandFail, "timeout 1ms exceeded")
+	err := Call(state, command)
+	assert.EqualError(test, err, "timeout 1ms exceeded")
+	state.AssertExpectations(test)
+}
+
+func TestCallReportsWrongTimeout(test *testing.T) {
+	state := newState()
+	command := map[interface{}]interface{}{"command": "CMD", "timeout": "soon"}
+	state.On("Log", darius.LogCommandFail, "timeout should be positive "+
+		"duration like \"30s\" or \"5m\": soon")
+	err := Call(state, command)
+	assert.Error(test, err)
+	state.AssertExpectations(test)
+}
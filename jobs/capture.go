@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/idfly/darius"
+)
+
+// capture collects stdout of command with `id` and stores it as output of
+// step available in `${steps.ID.stdout}`, `${steps.ID.lines}`,
+// `${steps.ID.json}` and `${steps.ID.status}`
+type capture struct {
+	id     string
+	kind   string
+	stdout []string
+}
+
+func newCapture(task map[interface{}]interface{}) (*capture, error) {
+	rawID, hasID := task["id"]
+	rawKind, hasKind := task["capture"]
+	if !hasID && !hasKind {
+		return nil, nil
+	}
+
+	id, ok := rawID.(string)
+	if !ok {
+		return nil, errors.New("id should be string")
+	}
+
+	if !hasKind {
+		rawKind = "stdout"
+	}
+
+	kind, ok := rawKind.(string)
+	if !ok || (kind != "stdout" && kind != "json" && kind != "lines") {
+		return nil, errors.New("capture should be stdout, json or lines")
+	}
+
+	return &capture{id: id, kind: kind}, nil
+}
+
+func (capture *capture) append(message string) {
+	capture.stdout = append(capture.stdout, message)
+}
+
+func (capture *capture) save(state darius.State, status int) error {
+	if state.DryRun() {
+		return nil
+	}
+
+	step := map[interface{}]interface{}{
+		"stdout": strings.TrimSpace(strings.Join(capture.stdout, "\n")),
+		"status": status,
+	}
+
+	if capture.kind == "lines" {
+		lines := []interface{}{}
+		for _, line := range capture.stdout {
+			lines = append(lines, line)
+		}
+
+		step["lines"] = lines
+	}
+
+	var err error
+	if capture.kind == "json" && status == 0 {
+		var value interface{}
+		err = json.Unmarshal([]byte(step["stdout"].(string)), &value)
+		if err != nil {
+			err = errors.New("failed to parse output of step " + capture.id +
+				" as json: " + err.Error())
+		}
+
+		step["json"] = yamlValue(value)
+	}
+
+	state.SetStep(capture.id, step)
+	return err
+}
+
+// yamlValue converts decoded json into values used in configuration
+func yamlValue(value interface{}) interface{} {
+	mapping, ok := value.(map[string]interface{})
+	if ok {
+		result := map[interface{}]interface{}{}
+		for key, value := range mapping {
+			result[key] = yamlValue(value)
+		}
+
+		return result
+	}
+
+	array, ok := value.([]interface{})
+	if ok {
+		for index, value := range array {
+			array[index] = yamlValue(value)
+		}
+
+		return array
+	}
+
+	number, ok := value.(float64)
+	if ok && number == float64(int(number)) {
+		return int(number)
+	}
+
+	return value
+}
@@ -11,6 +11,20 @@ import (
 	"github.com/shagabutdinov/shell"
 )
 
+// ExitError is returned when command exits with non-zero status
+type ExitError struct {
+	Status int
+}
+
+func (err *ExitError) ExitStatus() int {
+	return err.Status
+}
+
+func (err *ExitError) Error() string {
+	return "command execution failed: non-zero exit status " +
+		strconv.Itoa(err.Status) + " received"
+}
+
 func Execute(state darius.State, task map[interface{}]interface{}) error {
 	report, err := execute(state, task)
 	if err != nil {
@@ -30,24 +44,29 @@ func execute(
 ) (bool, error) {
 	_, ok := task["command"]
 	if !ok {
-		return true, errors.New("command should be defined in task")
+		return executeScript(state, task)
 	}
 
-	var err error
-	task["command"], err = state.Expand(task["command"], false)
-	if err != nil {
-		return true, err
+	_, isString := task["command"].(string)
+	_, hasID := task["id"]
+	_, hasCapture := task["capture"]
+	if !isString && (hasID || hasCapture) {
+		return true, errors.New("id and capture should be set on command " +
+			"which is string")
 	}
 
+	// command is already expanded by Spawn and elements of array are
+	// expanded by Spawn of subtasks; expanding them here again would expand
+	// values of expressions
 	array, ok := task["command"].([]interface{})
-	if ok {
-		for index, element := range array {
-			array[index], err = state.Expand(element, false)
-			if err != nil {
-				return true, err
-			}
+	if ok && task["parallel"] == true {
+		return false, state.Call("parallel", task)
+	}
 
-			err := state.Call("call", darius.CreateTask(array[index]))
+	if ok {
+		for _, element := range array {
+			subtask := inheritInterpreter(task, darius.CreateTask(element))
+			err := state.Call("call", subtask)
 			if err != nil {
 				return false, err
 			}
@@ -58,7 +77,7 @@ func execute(
 
 	mapping, ok := task["command"].(map[interface{}]interface{})
 	if ok {
-		return false, state.Call("call", mapping)
+		return false, state.Call("call", inheritInterpreter(task, mapping))
 	}
 
 	str, ok := task["command"].(string)
@@ -66,12 +85,69 @@ func execute(
 		return true, errors.New("command should be string, array or map")
 	}
 
+	interpreter, err := findInterpreter(state, task)
+	if err != nil {
+		return true, err
+	}
+
+	if interpreter != "" {
+		return runScript(state, task, str, interpreter, str)
+	}
+
+	return runCommand(state, task, str, str)
+}
+
+// executeScript runs script of task as single file; scripts are run with bash
+// unless other interpreter is set
+func executeScript(
+	state darius.State,
+	task map[interface{}]interface{},
+) (bool, error) {
+	raw, ok := task["script"]
+	if !ok {
+		return true, errors.New("command should be defined in task")
+	}
+
+	// script is already expanded by Spawn
+	script, ok := raw.(string)
+	if !ok {
+		return true, errors.New("script should be string")
+	}
+
+	interpreter, err := findInterpreter(state, task)
+	if err != nil {
+		return true, err
+	}
+
+	if interpreter == "" {
+		interpreter = "bash"
+	}
+
+	strict := strictScript(interpreter, script)
+	return runScript(state, task, script, interpreter, strict)
+}
+
+// runCommand logs str and executes command which runs it
+func runCommand(
+	state darius.State,
+	task map[interface{}]interface{},
+	str string,
+	command string,
+) (bool, error) {
+	capture, err := newCapture(task)
+	if err != nil {
+		return true, err
+	}
+
 	state.Log(darius.LogCommand, str)
 	status, err := state.Execute(
-		str,
+		command,
 		func(kind shell.MessageType, message string) error {
 			if kind == shell.StdOut {
 				state.Log(darius.LogStdOut, message)
+				if capture != nil {
+					capture.append(message)
+				}
 			} else if kind == shell.StdErr {
 				state.Log(darius.LogStdErr, message)
 			} else {
@@ -83,12 +159,18 @@ func execute(
 	)
 
 	if err != nil {
-		return true, err
+		return err != state.Context().Err(), err
+	}
+
+	if capture != nil {
+		err = capture.save(state, status)
+		if err != nil {
+			return true, err
+		}
 	}
 
 	if status != 0 {
-		return true, errors.New("command execution failed: non-zero exit " +
-			"status " + strconv.Itoa(status) + " received")
+		return true, &ExitError{Status: status}
 	}
 
 	return false, nil
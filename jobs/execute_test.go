@@ -1,12 +1,15 @@
 package jobs
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/idfly/darius"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestExecuteReturnsErrOnUndefinedCommand(test *testing.T) {
@@ -71,3 +74,158 @@ func TestExecuteReturnsErrorOnNonZeroExitStatus(test *testing.T) {
 	err := Execute(state, map[interface{}]interface{}{"command": "COMMAND"})
 	assert.Error(test, err)
 }
+
+func TestExecuteCapturesStdout(test *testing.T) {
+	state := newState()
+	state.On("Execute", "COMMAND").Return(0, nil)
+	state.On("Log", mock.Anything, mock.Anything).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{
+		"id":      "ID",
+		"capture": "lines",
+		"command": "COMMAND",
+	})
+
+	assert.NoError(test, err)
+	step, ok := state.Step("ID")
+	assert.True(test, ok)
+	assert.Equal(test, map[interface{}]interface{}{
+		"stdout": "OUT",
+		"status": 0,
+		"lines":  []interface{}{"OUT"},
+	}, step)
+}
+
+func TestExecuteReportsWrongJSONOutput(test *testing.T) {
+	state := newState()
+	state.On("Execute", "COMMAND").Return(0, nil)
+	state.On("Log", mock.Anything, mock.Anything).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{
+		"id":      "ID",
+		"capture": "json",
+		"command": "COMMAND",
+	})
+
+	assert.EqualError(test, err, "failed to parse output of step ID as json: "+
+		"invalid character 'O' looking for beginning of value")
+}
+
+func TestExecuteRunsScriptWithBash(test *testing.T) {
+	state := newState()
+	state.On("Execute", mock.MatchedBy(func(command string) bool {
+		return strings.Contains(command, "set -euo pipefail\necho 1\n") &&
+			strings.Contains(command, `bash "$file"`)
+	})).Return(0, nil)
+	state.On("Log", darius.LogCommand, "echo 1").Return(nil)
+	state.On("Log", mock.Anything, mock.Anything).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{"script": "echo 1"})
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestExecuteRunsScriptWithShInStrictMode(test *testing.T) {
+	state := newState()
+	state.On("Execute", mock.MatchedBy(func(command string) bool {
+		return strings.Contains(command, "set -eu\necho 1\n") &&
+			strings.Contains(command, `sh "$file"`)
+	})).Return(0, nil)
+	state.On("Log", mock.Anything, mock.Anything).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{
+		"shell":  "sh",
+		"script": "echo 1",
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestExecuteRemovesScriptOfInterruptedCommand(test *testing.T) {
+	state := newState()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	state.ctx = ctx
+	file := ""
+	state.On("Execute", mock.MatchedBy(func(command string) bool {
+		if !strings.HasPrefix(command, "(") {
+			return false
+		}
+
+		file = strings.Split(strings.Split(command, "\n")[2], `"`)[1]
+		return true
+	})).Return(-1, context.Canceled)
+	state.On("Execute", mock.MatchedBy(func(command string) bool {
+		return file != "" && command == `rm -f "`+file+`"`
+	})).Return(0, nil)
+	state.On("Log", mock.Anything, mock.Anything).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{"script": "sleep 5"})
+
+	assert.Equal(test, context.Canceled, err)
+	assert.Contains(test, file, "darius-script-")
+	state.AssertExpectations(test)
+}
+
+func TestExecuteRunsCommandWithInterpreterOfTask(test *testing.T) {
+	state := newState()
+	state.On("Execute", mock.MatchedBy(func(command string) bool {
+		return strings.Contains(command, "\nprint(1)\n") &&
+			strings.Contains(command, `python3 -u "$file"`)
+	})).Return(0, nil)
+	state.On("Log", mock.Anything, mock.Anything).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{
+		"shell":   "python3 -u",
+		"command": "print(1)",
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestExecuteRunsCommandWithBashWithoutStrictMode(test *testing.T) {
+	state := newState()
+	state.On("Execute", mock.MatchedBy(func(command string) bool {
+		return !strings.Contains(command, "set -euo pipefail") &&
+			strings.Contains(command, `bash "$file"`)
+	})).Return(0, nil)
+	state.On("Log", mock.Anything, mock.Anything).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{
+		"shell":   "bash",
+		"command": "echo 1",
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestExecutePassesInterpreterToSubtasks(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", map[interface{}]interface{}{
+		"shell":   "python3",
+		"command": "print(1)",
+	}).Return(nil)
+	state.On("Call", "call", map[interface{}]interface{}{
+		"shell":   "sh",
+		"command": "echo 1",
+	}).Return(nil)
+	err := Execute(state, map[interface{}]interface{}{
+		"shell": "python3",
+		"command": []interface{}{
+			"print(1)",
+			map[interface{}]interface{}{"shell": "sh", "command": "echo 1"},
+		},
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestExecuteReportsCaptureOfArray(test *testing.T) {
+	state := newState()
+	state.On("Log", darius.LogCommandFail, "id and capture should be set on "+
+		"command which is string")
+	err := Execute(state, map[interface{}]interface{}{
+		"id":      "ID",
+		"command": []interface{}{"A", "B"},
+	})
+
+	assert.Error(test, err)
+	state.AssertExpectations(test)
+}
@@ -0,0 +1,315 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/idfly/darius"
+)
+
+// FingerprintDir is directory where fingerprints of successful runs of tasks
+// with sources or outputs are stored
+var FingerprintDir = ".darius/fingerprints"
+
+// fingerprint is hash of task body and its source files; task is skipped if
+// fingerprint did not change since last successful run and outputs exist
+type fingerprint struct {
+	file    string
+	body    string
+	sources []string
+	outputs []string
+}
+
+func newFingerprint(
+	state darius.State,
+	task map[interface{}]interface{},
+) (*fingerprint, error) {
+	_, hasSources := task["sources"]
+	_, hasOutputs := task["outputs"]
+	if !hasSources && !hasOutputs {
+		return nil, nil
+	}
+
+	result := &fingerprint{}
+	for key, target := range map[string]*[]string{
+		"sources": &result.sources,
+		"outputs": &result.outputs,
+	} {
+		var err error
+		*target, err = parsePatterns(state, task, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// nested commands are expanded only when they are run; body which uses
+	// values known only while running, e.g. steps, is never skipped
+	body, err := expandBody(state, []interface{}{task["job"], task["command"],
+		task["script"]})
+	if err != nil {
+		return nil, nil
+	}
+
+	result.body = fmt.Sprint(body)
+	key := sha256.Sum256([]byte(fmt.Sprint(task["name"], result.sources,
+		result.outputs, result.body)))
+	result.file = filepath.Join(FingerprintDir, hex.EncodeToString(key[:]))
+	return result, nil
+}
+
+// expandBody expands strings in nested maps and arrays of body
+func expandBody(state darius.State, value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return state.Expand(value, true)
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for index, element := range value {
+			var err error
+			result[index], err = expandBody(state, element)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return result, nil
+	case map[interface{}]interface{}:
+		result := map[interface{}]interface{}{}
+		for key, element := range value {
+			var err error
+			result[key], err = expandBody(state, element)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return result, nil
+	}
+
+	return value, nil
+}
+
+func parsePatterns(
+	state darius.State,
+	task map[interface{}]interface{},
+	key string,
+) ([]string, error) {
+	raw, ok := task[key]
+	if !ok {
+		return nil, nil
+	}
+
+	raw, err := state.Expand(raw, true)
+	if err != nil {
+		return nil, err
+	}
+
+	str, ok := raw.(string)
+	if ok {
+		return []string{str}, nil
+	}
+
+	array, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New(key + " should be string or array of strings")
+	}
+
+	result := []string{}
+	for _, element := range array {
+		str, ok := element.(string)
+		if !ok {
+			return nil, errors.New(key + " should be string or array of " +
+				"strings")
+		}
+
+		result = append(result, str)
+	}
+
+	return result, nil
+}
+
+// fresh checks if task was already run successfully with the same sources
+func (fingerprint *fingerprint) fresh() (bool, error) {
+	for _, pattern := range fingerprint.outputs {
+		files, err := glob(pattern)
+		if err != nil || len(files) == 0 {
+			return false, err
+		}
+	}
+
+	saved, err := ioutil.ReadFile(fingerprint.file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	sum, err := fingerprint.sum()
+	if err != nil {
+		return false, err
+	}
+
+	return string(saved) == sum, nil
+}
+
+func (fingerprint *fingerprint) save() error {
+	sum, err := fingerprint.sum()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fingerprint.file), 0755)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fingerprint.file, []byte(sum), 0644)
+}
+
+func (fingerprint *fingerprint) sum() (string, error) {
+	files := []string{}
+	for _, pattern := range fingerprint.sources {
+		matches, err := glob(pattern)
+		if err != nil {
+			return "", err
+		}
+
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+
+	hash := sha256.New()
+	io.WriteString(hash, fingerprint.body+"\x00")
+	previous := ""
+	for _, file := range files {
+		if file == previous {
+			continue
+		}
+
+		previous = file
+		io.WriteString(hash, file+"\x00")
+		reader, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = io.Copy(hash, reader)
+		reader.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// glob returns files matching pattern; matched directories are walked
+// recursively
+func glob(pattern string) ([]string, error) {
+	matches, err := match(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, match := range matches {
+		err := filepath.Walk(match, func(
+			path string,
+			info os.FileInfo,
+			err error,
+		) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() {
+				result = append(result, path)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// match returns files and directories matching pattern; "**" matches any
+// number of directories
+func match(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+
+	expr, err := globExpression(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	root := pattern[:strings.Index(pattern, "**")]
+	root = filepath.Dir(root + "x")
+	err = filepath.Walk(root, func(
+		path string,
+		info os.FileInfo,
+		err error,
+	) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if expr.MatchString(filepath.ToSlash(path)) {
+			matches = append(matches, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+func globExpression(pattern string) (*regexp.Regexp, error) {
+	expr := ""
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	for index := 0; index < len(pattern); index++ {
+		switch {
+		case strings.HasPrefix(pattern[index:], "**/"):
+			expr += "(.*/)?"
+			index += 2
+		case strings.HasPrefix(pattern[index:], "**"):
+			expr += ".*"
+			index += 1
+		case pattern[index] == '*':
+			expr += "[^/]*"
+		case pattern[index] == '?':
+			expr += "[^/]"
+		default:
+			expr += regexp.QuoteMeta(pattern[index : index+1])
+		}
+	}
+
+	return regexp.Compile("^" + expr + "$")
+}
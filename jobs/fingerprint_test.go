@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/idfly/darius"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFingerprintTask(test *testing.T) (map[interface{}]interface{}, string) {
+	dir := test.TempDir()
+	previous := FingerprintDir
+	test.Cleanup(func() { FingerprintDir = previous })
+	FingerprintDir = filepath.Join(dir, "fingerprints")
+	source := filepath.Join(dir, "src", "main.go")
+	assert.NoError(test, os.MkdirAll(filepath.Dir(source), 0755))
+	assert.NoError(test, ioutil.WriteFile(filepath.Join(dir, "out"), nil, 0644))
+	assert.NoError(test, ioutil.WriteFile(source, []byte("1"), 0644))
+	return map[interface{}]interface{}{
+		"command": "CMD",
+		"sources": filepath.Join(dir, "src", "**", "*.go"),
+		"outputs": []interface{}{filepath.Join(dir, "out")},
+	}, source
+}
+
+func TestCallSkipsTaskWithUnchangedSources(test *testing.T) {
+	task, _ := newFingerprintTask(test)
+	state := newState()
+	state.On("Call", "execute", task).Return(nil).Once()
+	state.On("Log", darius.LogSystem, "sources did not change; skipped")
+	assert.NoError(test, Call(state, task))
+	assert.NoError(test, Call(state, task))
+	state.AssertExpectations(test)
+}
+
+func TestCallRunsTaskWithChangedSources(test *testing.T) {
+	task, source := newFingerprintTask(test)
+	state := newState()
+	state.On("Call", "execute", task).Return(nil).Twice()
+	assert.NoError(test, Call(state, task))
+	assert.NoError(test, ioutil.WriteFile(source, []byte("2"), 0644))
+	assert.NoError(test, Call(state, task))
+	state.AssertExpectations(test)
+}
+
+func TestCallRunsTaskWithoutOutputs(test *testing.T) {
+	task, _ := newFingerprintTask(test)
+	task["outputs"] = "missing"
+	state := newState()
+	state.On("Call", "execute", task).Return(nil).Twice()
+	assert.NoError(test, Call(state, task))
+	assert.NoError(test, Call(state, task))
+	state.AssertExpectations(test)
+}
+
+func TestCallRunsTaskAgainIfFailureWasRescued(test *testing.T) {
+	task, _ := newFingerprintTask(test)
+	task["rescue"] = "RESCUE"
+	state := newState()
+	state.On("Call", "execute", task).Return(errors.New("FAILED")).Twice()
+	state.On("Log", darius.LogRescue, "[rescue]")
+	state.On("Call", "call", map[interface{}]interface{}{"command": "RESCUE"}).
+		Return(nil)
+	assert.NoError(test, Call(state, task))
+	assert.NoError(test, Call(state, task))
+	state.AssertExpectations(test)
+}
+
+func TestCallRunsTaskWithChangedNestedCommand(test *testing.T) {
+	task, _ := newFingerprintTask(test)
+	task["command"] = []interface{}{"echo ${vars.version}"}
+	state := newState()
+	state.On("Call", "execute", task).Return(nil).Twice()
+	for _, version := range []string{"1", "2"} {
+		version := version
+		state.expand = func(value interface{}) interface{} {
+			str, ok := value.(string)
+			if !ok {
+				return value
+			}
+
+			return strings.ReplaceAll(str, "${vars.version}", version)
+		}
+
+		assert.NoError(test, Call(state, task))
+	}
+
+	state.AssertExpectations(test)
+}
+
+func TestCallRunsEnsureOfSkippedTask(test *testing.T) {
+	task, _ := newFingerprintTask(test)
+	task["ensure"] = "ENSURE"
+	state := newState()
+	state.On("Call", "execute", task).Return(nil).Once()
+	state.On("Log", darius.LogSystem, "sources did not change; skipped")
+	state.On("Log", darius.LogEnsure, "[ensure]")
+	state.On("Call", "call", map[interface{}]interface{}{"command": "ENSURE"}).
+		Return(nil).Twice()
+	assert.NoError(test, Call(state, task))
+	assert.NoError(test, Call(state, task))
+	state.AssertExpectations(test)
+}
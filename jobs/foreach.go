@@ -0,0 +1,145 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/idfly/darius"
+)
+
+// Foreach runs command of task once per element of items; items could be
+// array, map or file glob; element is available as `${args.item}` (name is
+// set with `as`) and its position as `${args.index}` and, for maps,
+// `${args.key}`
+func Foreach(state darius.State, task map[interface{}]interface{}) error {
+	report, err := foreach(state, task)
+	if err != nil {
+		if report {
+			state.Log(darius.LogCommandFail, err.Error())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func foreach(
+	state darius.State,
+	task map[interface{}]interface{},
+) (bool, error) {
+	command, ok := task["command"]
+	if !ok {
+		return true, errors.New("command should be defined in task")
+	}
+
+	items, err := parseItems(state, task)
+	if err != nil {
+		return true, err
+	}
+
+	as := "item"
+	raw, ok := task["as"]
+	if ok {
+		as, ok = raw.(string)
+		if !ok || as == "" {
+			return true, errors.New("as should be string")
+		}
+	}
+
+	tasks := []interface{}{}
+	for index, item := range items {
+		// params are expanded by Spawn while items are already expanded
+		params := map[interface{}]interface{}{
+			as:      darius.Escape(item.value),
+			"index": index,
+		}
+
+		if item.key != nil {
+			params["key"] = darius.Escape(item.key)
+		}
+
+		tasks = append(tasks, inheritInterpreter(task,
+			map[interface{}]interface{}{
+				"params":  params,
+				"command": darius.Copy(command),
+			}))
+	}
+
+	if task["parallel"] == true {
+		parallelTask := map[interface{}]interface{}{"command": tasks}
+		for _, key := range []string{"max", "fail-fast"} {
+			value, ok := task[key]
+			if ok {
+				parallelTask[key] = value
+			}
+		}
+
+		return false, state.Call("parallel", parallelTask)
+	}
+
+	for _, current := range tasks {
+		err := state.Call("call", current.(map[interface{}]interface{}))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+type foreachItem struct {
+	key   interface{}
+	value interface{}
+}
+
+func parseItems(
+	state darius.State,
+	task map[interface{}]interface{},
+) ([]foreachItem, error) {
+	raw, ok := task["items"]
+	if !ok {
+		return nil, errors.New("items should be defined in task")
+	}
+
+	raw, err := state.Expand(raw, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []foreachItem{}
+	switch items := raw.(type) {
+	case []interface{}:
+		for _, value := range items {
+			result = append(result, foreachItem{nil, value})
+		}
+	case map[interface{}]interface{}:
+		keys := []interface{}{}
+		for key := range items {
+			keys = append(keys, key)
+		}
+
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+
+		for _, key := range keys {
+			result = append(result, foreachItem{key, items[key]})
+		}
+	case string:
+		matches, err := match(items)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(matches)
+		for _, value := range matches {
+			result = append(result, foreachItem{nil, value})
+		}
+	default:
+		return nil, errors.New("items should be array, map or file glob")
+	}
+
+	return result, nil
+}
@@ -0,0 +1,125 @@
+package jobs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/idfly/darius"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func foreachTask(params map[interface{}]interface{}) map[interface{}]interface{} {
+	return map[interface{}]interface{}{"params": params, "command": "CMD"}
+}
+
+func TestForeachCallsCommandForEveryItem(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", foreachTask(map[interface{}]interface{}{
+		"service": "api", "index": 0,
+	})).Return(nil)
+	state.On("Call", "call", foreachTask(map[interface{}]interface{}{
+		"service": "web", "index": 1,
+	})).Return(nil)
+
+	err := Foreach(state, map[interface{}]interface{}{
+		"items":   []interface{}{"api", "web"},
+		"as":      "service",
+		"command": "CMD",
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestForeachPassesKeysOfMap(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", foreachTask(map[interface{}]interface{}{
+		"item": 1, "key": "a", "index": 0,
+	})).Return(nil)
+	state.On("Call", "call", foreachTask(map[interface{}]interface{}{
+		"item": 2, "key": "b", "index": 1,
+	})).Return(nil)
+
+	err := Foreach(state, map[interface{}]interface{}{
+		"items":   map[interface{}]interface{}{"b": 2, "a": 1},
+		"command": "CMD",
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestForeachIteratesOverGlob(test *testing.T) {
+	dir := test.TempDir()
+	for _, name := range []string{"b.yml", "a.yml", "c.txt"} {
+		assert.NoError(test, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	state := newState()
+	state.On("Call", "call", foreachTask(map[interface{}]interface{}{
+		"item": filepath.Join(dir, "a.yml"), "index": 0,
+	})).Return(nil)
+	state.On("Call", "call", foreachTask(map[interface{}]interface{}{
+		"item": filepath.Join(dir, "b.yml"), "index": 1,
+	})).Return(nil)
+
+	err := Foreach(state, map[interface{}]interface{}{
+		"items":   filepath.Join(dir, "*.yml"),
+		"command": "CMD",
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestForeachStopsOnFailedItem(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", foreachTask(map[interface{}]interface{}{
+		"item": "api", "index": 0,
+	})).Return(errors.New("ERROR"))
+
+	err := Foreach(state, map[interface{}]interface{}{
+		"items":   []interface{}{"api", "web"},
+		"command": "CMD",
+	})
+
+	assert.EqualError(test, err, "ERROR")
+	state.AssertExpectations(test)
+}
+
+func TestForeachRunsItemsInParallel(test *testing.T) {
+	state := newState()
+	state.On("Call", "parallel", map[interface{}]interface{}{
+		"command": []interface{}{
+			foreachTask(map[interface{}]interface{}{"item": "api", "index": 0}),
+		},
+		"max": 2,
+	}).Return(nil)
+
+	err := Foreach(state, map[interface{}]interface{}{
+		"items":    []interface{}{"api"},
+		"command":  "CMD",
+		"parallel": true,
+		"max":      2,
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestForeachReportsWrongItems(test *testing.T) {
+	state := newState()
+	state.On("Log", darius.LogCommandFail,
+		"items should be array, map or file glob")
+
+	err := Foreach(state, map[interface{}]interface{}{
+		"items":   1,
+		"command": "CMD",
+	})
+
+	assert.Error(test, err)
+	state.AssertExpectations(test)
+}
@@ -0,0 +1,17 @@
+package jobs
+
+import (
+	"github.com/idfly/darius"
+)
+
+var (
+	// Funcs contains jobs available in configuration by name
+	Funcs = map[string]darius.Job{
+		"call":          Call,
+		"execute":       Execute,
+		"foreach":       Foreach,
+		"parallel":      Parallel,
+		"run":           Run,
+		"run-user-task": RunUserTask,
+	}
+)
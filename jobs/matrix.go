@@ -0,0 +1,211 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/idfly/darius"
+)
+
+// runMatrix calls task once for every combination of values in its matrix;
+// values of combination are available in args; all combinations are run and
+// failed ones are reported at the end
+func runMatrix(state darius.State, task map[interface{}]interface{}) error {
+	combinations, err := parseMatrix(state, task)
+	if err != nil {
+		state.Log(darius.LogCommandFail, err.Error())
+		return err
+	}
+
+	failed := []string{}
+	for _, combination := range combinations {
+		child, err := matrixTask(task, combination)
+		if err != nil {
+			state.Log(darius.LogCommandFail, err.Error())
+			return err
+		}
+
+		err = state.Call("call", child)
+		if state.Context().Err() != nil {
+			return state.Context().Err()
+		}
+
+		if err != nil {
+			failed = append(failed, matrixLabel(combination))
+		}
+	}
+
+	if len(failed) > 0 {
+		err := errors.New(strconv.Itoa(len(failed)) + " of " +
+			strconv.Itoa(len(combinations)) + " combinations failed: " +
+			strings.Join(failed, "; "))
+		state.Log(darius.LogCommandFail, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// parseMatrix returns cartesian product of matrix values without combinations
+// matching `exclude` and with combinations from `include`
+func parseMatrix(
+	state darius.State,
+	task map[interface{}]interface{},
+) ([]map[interface{}]interface{}, error) {
+	raw, err := state.Expand(task["matrix"], true)
+	if err != nil {
+		return nil, err
+	}
+
+	matrix, ok := raw.(map[interface{}]interface{})
+	if !ok || len(matrix) == 0 {
+		return nil, errors.New("matrix should be map of arrays")
+	}
+
+	keys := []interface{}{}
+	for key := range matrix {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	combinations := []map[interface{}]interface{}{{}}
+	for _, key := range keys {
+		values, ok := matrix[key].([]interface{})
+		if !ok {
+			return nil, errors.New("matrix values of " + fmt.Sprint(key) +
+				" should be array")
+		}
+
+		product := []map[interface{}]interface{}{}
+		for _, combination := range combinations {
+			for _, value := range values {
+				current := darius.Merge(combination,
+					map[interface{}]interface{}{key: value})
+				product = append(product, current)
+			}
+		}
+
+		combinations = product
+	}
+
+	exclude, err := parseCombinations(state, task, "exclude")
+	if err != nil {
+		return nil, err
+	}
+
+	include, err := parseCombinations(state, task, "include")
+	if err != nil {
+		return nil, err
+	}
+
+	result := []map[interface{}]interface{}{}
+	for _, combination := range combinations {
+		excluded := false
+		for _, current := range exclude {
+			if matchesCombination(combination, current) {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			result = append(result, combination)
+		}
+	}
+
+	return append(result, include...), nil
+}
+
+func parseCombinations(
+	state darius.State,
+	task map[interface{}]interface{},
+	key string,
+) ([]map[interface{}]interface{}, error) {
+	raw, ok := task[key]
+	if !ok {
+		return nil, nil
+	}
+
+	raw, err := state.Expand(raw, true)
+	if err != nil {
+		return nil, err
+	}
+
+	array, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New(key + " should be array of maps")
+	}
+
+	result := []map[interface{}]interface{}{}
+	for _, element := range array {
+		mapping, ok := element.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New(key + " should be array of maps")
+		}
+
+		result = append(result, mapping)
+	}
+
+	return result, nil
+}
+
+func matchesCombination(
+	combination map[interface{}]interface{},
+	pattern map[interface{}]interface{},
+) bool {
+	for key, value := range pattern {
+		if fmt.Sprint(combination[key]) != fmt.Sprint(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matrixTask(
+	task map[interface{}]interface{},
+	combination map[interface{}]interface{},
+) (map[interface{}]interface{}, error) {
+	result := darius.Copy(task).(map[interface{}]interface{})
+	delete(result, "matrix")
+	delete(result, "exclude")
+	delete(result, "include")
+
+	params := map[interface{}]interface{}{}
+	raw, ok := result["params"]
+	if ok {
+		params, ok = raw.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("params should be map")
+		}
+	}
+
+	// params are expanded by Spawn while combination is already expanded
+	escaped := darius.Escape(combination).(map[interface{}]interface{})
+	result["params"] = darius.Merge(params, escaped)
+
+	label := "(" + matrixLabel(combination) + ")"
+	name, ok := result["name"].(string)
+	if ok {
+		label = name + " " + label
+	}
+
+	result["name"] = label
+	return result, nil
+}
+
+func matrixLabel(combination map[interface{}]interface{}) string {
+	pairs := []string{}
+	for key, value := range combination {
+		pairs = append(pairs, fmt.Sprint(key)+"="+fmt.Sprint(value))
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
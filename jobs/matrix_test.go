@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/idfly/darius"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func matrixChild(
+	name string,
+	params map[interface{}]interface{},
+) map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"name":    name,
+		"params":  params,
+		"command": "CMD",
+	}
+}
+
+func TestCallRunsMatrixCombinations(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", matrixChild("build (go=1.6, image=alpine)",
+		map[interface{}]interface{}{"go": 1.6, "image": "alpine"})).Return(nil)
+	state.On("Call", "call", matrixChild("build (go=1.7, image=alpine)",
+		map[interface{}]interface{}{"go": 1.7, "image": "alpine"})).Return(nil)
+	state.On("Call", "call", matrixChild("build (go=1.7, image=debian)",
+		map[interface{}]interface{}{"go": 1.7, "image": "debian"})).Return(nil)
+	state.On("Call", "call", matrixChild("build (go=1.8, image=scratch)",
+		map[interface{}]interface{}{"go": 1.8, "image": "scratch"})).Return(nil)
+
+	err := Call(state, map[interface{}]interface{}{
+		"name": "build",
+		"matrix": map[interface{}]interface{}{
+			"go":    []interface{}{1.6, 1.7},
+			"image": []interface{}{"alpine", "debian"},
+		},
+		"exclude": []interface{}{
+			map[interface{}]interface{}{"go": 1.6, "image": "debian"},
+		},
+		"include": []interface{}{
+			map[interface{}]interface{}{"go": 1.8, "image": "scratch"},
+		},
+		"command": "CMD",
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestCallReportsFailedMatrixCombinations(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", matrixChild("(go=1.6)",
+		map[interface{}]interface{}{"go": 1.6})).Return(errors.New("ERROR"))
+	state.On("Call", "call", matrixChild("(go=1.7)",
+		map[interface{}]interface{}{"go": 1.7})).Return(nil)
+	state.On("Log", darius.LogCommandFail, "1 of 2 combinations failed: "+
+		"go=1.6")
+
+	err := Call(state, map[interface{}]interface{}{
+		"matrix":  map[interface{}]interface{}{"go": []interface{}{1.6, 1.7}},
+		"command": "CMD",
+	})
+
+	assert.EqualError(test, err, "1 of 2 combinations failed: go=1.6")
+	state.AssertExpectations(test)
+}
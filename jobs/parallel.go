@@ -0,0 +1,116 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/idfly/darius"
+)
+
+// Parallel runs commands of task concurrently, each in its own shell; max
+// limits number of simultaneously running commands; with fail-fast remaining
+// commands are interrupted after first failure
+func Parallel(state darius.State, task map[interface{}]interface{}) error {
+	report, err := parallel(state, task)
+	if err != nil {
+		if report {
+			state.Log(darius.LogCommandFail, err.Error())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func parallel(
+	state darius.State,
+	task map[interface{}]interface{},
+) (bool, error) {
+	_, ok := task["command"]
+	if !ok {
+		return true, errors.New("command should be defined in task")
+	}
+
+	commands, ok := task["command"].([]interface{})
+	if !ok {
+		return true, errors.New("command of parallel job should be array")
+	}
+
+	max := len(commands)
+	raw, ok := task["max"]
+	if ok {
+		max, ok = raw.(int)
+		if !ok || max < 1 {
+			return true, errors.New("max should be positive number")
+		}
+	}
+
+	failFast := false
+	raw, ok = task["fail-fast"]
+	if ok {
+		failFast, ok = raw.(bool)
+		if !ok {
+			return true, errors.New("fail-fast should be boolean")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(state.Context())
+	defer cancel()
+	parent := state.WithContext(ctx)
+
+	limit := make(chan bool, max)
+	errs := make([]error, len(commands))
+	var wait sync.WaitGroup
+	for index, command := range commands {
+		limit <- true
+		if ctx.Err() != nil {
+			errs[index] = ctx.Err()
+			<-limit
+			continue
+		}
+
+		wait.Add(1)
+		go func(index int, command interface{}) {
+			defer wait.Done()
+			defer func() {
+				<-limit
+			}()
+
+			child := parent.Fork("[" + strconv.Itoa(index+1) + "] ")
+			subtask := inheritInterpreter(task, darius.CreateTask(command))
+			errs[index] = child.Call("call", subtask)
+			err := child.Destroy()
+			if err != nil {
+				log.Println(err)
+			}
+
+			if errs[index] != nil && failFast {
+				cancel()
+			}
+		}(index, command)
+	}
+
+	wait.Wait()
+
+	if state.Context().Err() != nil {
+		return false, state.Context().Err()
+	}
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed += 1
+		}
+	}
+
+	if failed != 0 {
+		return true, errors.New(strconv.Itoa(failed) + " of " +
+			strconv.Itoa(len(commands)) + " parallel commands failed")
+	}
+
+	return false, nil
+}
@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/idfly/darius"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelRunsCommands(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", map[interface{}]interface{}{"command": "CMD1"}).
+		Return(nil)
+	state.On("Call", "call", map[interface{}]interface{}{"command": "CMD2"}).
+		Return(nil)
+	err := Parallel(state, map[interface{}]interface{}{
+		"command": []interface{}{"CMD1", "CMD2"},
+	})
+
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestParallelWaitsAllCommands(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", map[interface{}]interface{}{"command": "CMD1"}).
+		Return(errors.New("ERROR"))
+	state.On("Call", "call", map[interface{}]interface{}{"command": "CMD2"}).
+		Return(nil)
+	state.On("Log", darius.LogCommandFail, "1 of 2 parallel commands failed")
+	err := Parallel(state, map[interface{}]interface{}{
+		"command": []interface{}{"CMD1", "CMD2"},
+		"max":     1,
+	})
+
+	assert.EqualError(test, err, "1 of 2 parallel commands failed")
+	state.AssertExpectations(test)
+}
+
+func TestParallelFailsFast(test *testing.T) {
+	state := newState()
+	state.On("Call", "call", map[interface{}]interface{}{"command": "CMD1"}).
+		Return(errors.New("ERROR"))
+	state.On("Log", darius.LogCommandFail, "2 of 2 parallel commands failed")
+	err := Parallel(state, map[interface{}]interface{}{
+		"command":   []interface{}{"CMD1", "CMD2"},
+		"max":       1,
+		"fail-fast": true,
+	})
+
+	assert.Error(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestExecuteRunsParallelArray(test *testing.T) {
+	state := newState()
+	task := map[interface{}]interface{}{
+		"command":  []interface{}{"CMD1", "CMD2"},
+		"parallel": true,
+	}
+
+	state.On("Call", "parallel", task).Return(nil)
+	err := Execute(state, task)
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
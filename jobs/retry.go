@@ -0,0 +1,155 @@
+package jobs
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/idfly/darius"
+)
+
+const (
+	backoffConstant    = "constant"
+	backoffExponential = "exponential"
+)
+
+type retry struct {
+	attempts  int
+	delay     time.Duration
+	backoff   string
+	exitCodes []int
+}
+
+// parseRetry reads retry policy of task which is either number of attempts
+// or map with attempts, delay, backoff and on-exit-codes
+func parseRetry(
+	state darius.State,
+	task map[interface{}]interface{},
+) (*retry, error) {
+	raw, ok := task["retry"]
+	if !ok {
+		return nil, nil
+	}
+
+	raw, err := state.Expand(raw, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &retry{backoff: backoffConstant}
+	config, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		config = map[interface{}]interface{}{"attempts": raw}
+	}
+
+	result.attempts, ok = config["attempts"].(int)
+	if !ok || result.attempts < 1 {
+		return nil, errors.New("retry attempts should be positive number")
+	}
+
+	delay, ok := config["delay"]
+	if ok {
+		str, ok := delay.(string)
+		if !ok {
+			return nil, errors.New("retry delay should be string")
+		}
+
+		result.delay, err = time.ParseDuration(str)
+		if err != nil || result.delay < 0 {
+			return nil, errors.New("retry delay should be duration like " +
+				"\"5s\": " + str)
+		}
+	}
+
+	backoff, ok := config["backoff"]
+	if ok {
+		result.backoff, ok = backoff.(string)
+		if !ok || (result.backoff != backoffConstant &&
+			result.backoff != backoffExponential) {
+			return nil, errors.New("retry backoff should be \"" +
+				backoffConstant + "\" or \"" + backoffExponential + "\"")
+		}
+	}
+
+	exitCodes, ok := config["on-exit-codes"]
+	if ok {
+		array, ok := exitCodes.([]interface{})
+		if !ok {
+			return nil, errors.New("retry on-exit-codes should be array")
+		}
+
+		for _, element := range array {
+			code, ok := element.(int)
+			if !ok {
+				return nil, errors.New("retry on-exit-codes should contain " +
+					"numbers")
+			}
+
+			result.exitCodes = append(result.exitCodes, code)
+		}
+	}
+
+	return result, nil
+}
+
+// runRetry runs mapping of task until it succeeds or attempts are exhausted
+func runRetry(
+	state darius.State,
+	task map[interface{}]interface{},
+	retry *retry,
+) (bool, error) {
+	if retry == nil {
+		return runMapping(state, task)
+	}
+
+	delay := retry.delay
+	for attempt := 1; ; attempt++ {
+		report, err := runMapping(state, task)
+		if err == nil || attempt >= retry.attempts ||
+			!retry.matches(state, err) {
+			return report, err
+		}
+
+		if report {
+			state.Log(darius.LogCommandFail, err.Error())
+		}
+
+		state.Log(darius.LogRetry, "retrying in "+delay.String()+" (attempt "+
+			strconv.Itoa(attempt+1)+" of "+strconv.Itoa(retry.attempts)+")")
+
+		select {
+		case <-time.After(delay):
+		case <-state.Context().Done():
+			return false, state.Context().Err()
+		}
+
+		if retry.backoff == backoffExponential {
+			delay *= 2
+		}
+	}
+}
+
+// matches checks if error should be retried; commands are retried only on
+// listed exit codes if on-exit-codes is set
+func (retry *retry) matches(state darius.State, err error) bool {
+	if state.Context().Err() != nil {
+		return false
+	}
+
+	if len(retry.exitCodes) == 0 {
+		return true
+	}
+
+	var exit *ExitError
+	if !errors.As(err, &exit) {
+		return false
+	}
+
+	for _, code := range retry.exitCodes {
+		if code == exit.Status {
+			return true
+		}
+	}
+
+	return false
+}
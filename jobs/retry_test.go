@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/idfly/darius"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallRetriesFailedCommand(test *testing.T) {
+	state := newState()
+	command := map[interface{}]interface{}{"command": "CMD", "retry": 3}
+	state.On("Call", "execute", command).Return(errors.New("ERROR")).Once()
+	state.On("Call", "execute", command).Return(nil).Once()
+	state.On("Log", darius.LogRetry, "retrying in 0s (attempt 2 of 3)")
+	err := Call(state, command)
+	assert.NoError(test, err)
+	state.AssertExpectations(test)
+}
+
+func TestCallReturnsErrorAfterLastAttempt(test *testing.T) {
+	state := newState()
+	command := map[interface{}]interface{}{"command": "CMD", "retry": 2}
+	state.On("Call", "execute", command).Return(errors.New("ERROR")).Twice()
+	state.On("Log", darius.LogRetry, "retrying in 0s (attempt 2 of 2)")
+	err := Call(state, command)
+	assert.EqualError(test, err, "ERROR")
+	state.AssertExpectations(test)
+}
+
+func TestCallRetriesOnListedExitCodes(test *testing.T) {
+	state := newState()
+	command := map[interface{}]interface{}{"command": "CMD",
+		"retry": map[interface{}]interface{}{
+			"attempts":      3,
+			"delay":         "1ms",
+			"backoff":       "exponential",
+			"on-exit-codes": []interface{}{255},
+		},
+	}
+
+	state.On("Call", "execute", command).Return(&ExitError{255}).Once()
+	state.On("Call", "execute", command).Return(&ExitError{1}).Once()
+	state.On("Log", darius.LogRetry, "retrying in 1ms (attempt 2 of 3)")
+	err := Call(state, command)
+	assert.Equal(test, &ExitError{1}, err)
+	state.AssertExpectations(test)
+}
+
+func TestCallReportsWrongRetry(test *testing.T) {
+	state := newState()
+	command := map[interface{}]interface{}{"command": "CMD", "retry": "often"}
+	state.On("Log", darius.LogCommandFail, "retry attempts should be "+
+		"positive number")
+	err := Call(state, command)
+	assert.Error(test, err)
+	state.AssertExpectations(test)
+}
@@ -36,10 +36,5 @@ func run(state darius.State, task map[interface{}]interface{}) (error, bool) {
 		return errors.New("task name should be string"), true
 	}
 
-	userTask, ok := state.Config()["tasks"].(map[interface{}]interface{})[str]
-	if !ok {
-		return errors.New("task " + str + " not found"), true
-	}
-
-	return state.Call("call", darius.CreateTask(userTask)), false
+	return state.RunTask(str), false
 }
@@ -39,10 +39,5 @@ func runUserTask(
 		return errors.New("task name should be string"), true
 	}
 
-	userTask, ok := state.Config()["tasks"].(map[interface{}]interface{})[str]
-	if !ok {
-		return errors.New("task " + str + " not found"), true
-	}
-
-	return state.Call("call", darius.CreateTask(userTask)), false
+	return state.RunTask(str), false
 }
@@ -0,0 +1,148 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/shagabutdinov/shell"
+
+	"github.com/idfly/darius"
+)
+
+// findInterpreter returns interpreter set in `shell` or `interpreter` of task
+func findInterpreter(
+	state darius.State,
+	task map[interface{}]interface{},
+) (string, error) {
+	for _, key := range []string{"shell", "interpreter"} {
+		raw, ok := task[key]
+		if !ok {
+			continue
+		}
+
+		raw, err := state.Expand(raw, false)
+		if err != nil {
+			return "", err
+		}
+
+		interpreter, ok := raw.(string)
+		if !ok || strings.TrimSpace(interpreter) == "" {
+			return "", errors.New(key + " should be string")
+		}
+
+		return interpreter, nil
+	}
+
+	return "", nil
+}
+
+// inheritInterpreter passes interpreter of task to its subtask unless
+// subtask sets its own; tasks called by run and run-user-task are not
+// subtasks, so they do not inherit it
+func inheritInterpreter(
+	task map[interface{}]interface{},
+	subtask map[interface{}]interface{},
+) map[interface{}]interface{} {
+	for _, key := range []string{"shell", "interpreter"} {
+		_, ok := subtask[key]
+		if ok {
+			return subtask
+		}
+	}
+
+	for _, key := range []string{"shell", "interpreter"} {
+		value, ok := task[key]
+		if ok {
+			result := map[interface{}]interface{}{key: value}
+			for key, value := range subtask {
+				result[key] = value
+			}
+
+			return result
+		}
+	}
+
+	return subtask
+}
+
+// scriptFile returns random name of temporary file for script
+func scriptFile() (string, error) {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	return "${TMPDIR:-/tmp}/darius-script-" + hex.EncodeToString(random), nil
+}
+
+// scriptCommand returns shell command which writes script to file, runs it
+// with interpreter and removes file; script is passed in heredoc, so the
+// same command works locally and over ssh
+func scriptCommand(file string, interpreter string, script string) string {
+	script = strings.TrimRight(script, "\n")
+	sum := sha256.Sum256([]byte(script))
+	delimiter := "DARIUS_SCRIPT_" + hex.EncodeToString(sum[:8])
+	return strings.Join([]string{
+		"(",
+		"umask 077",
+		`file="` + file + `"`,
+		`set -C; cat > "$file" <<'` + delimiter + `' || exit`,
+		script,
+		delimiter,
+		`set +C; trap 'rm -f "$file"' EXIT`,
+		interpreter + ` "$file"`,
+		")",
+	}, "\n")
+}
+
+// runScript runs script with interpreter; file of script is removed within
+// grace period if command is interrupted before its trap is run
+func runScript(
+	state darius.State,
+	task map[interface{}]interface{},
+	str string,
+	interpreter string,
+	script string,
+) (bool, error) {
+	file, err := scriptFile()
+	if err != nil {
+		return true, err
+	}
+
+	command := scriptCommand(file, interpreter, script)
+	failed, err := runCommand(state, task, str, command)
+	if err != nil && err == state.Context().Err() {
+		ctx, cancel := darius.GraceContext(state.Context(), GracePeriod)
+		defer cancel()
+		_, removeErr := state.WithContext(ctx).Execute(
+			`rm -f "`+file+`"`,
+			func(shell.MessageType, string) error { return nil },
+		)
+
+		if removeErr != nil {
+			state.Log(darius.LogCommandFail, removeErr.Error())
+		}
+	}
+
+	return failed, err
+}
+
+// strictScript makes script exit on first failed command and on undefined
+// variable; pipefail is set for shells which support it and scripts of other
+// interpreters are left as is
+func strictScript(interpreter string, script string) string {
+	fields := strings.Fields(interpreter)
+	switch path.Base(fields[0]) {
+	case "bash", "zsh", "ksh":
+		return "set -euo pipefail\n" + script
+	case "sh", "dash", "ash":
+		return "set -eu\n" + script
+	}
+
+	return script
+}
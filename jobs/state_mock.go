@@ -1,20 +1,29 @@
 package jobs
 
 import (
+	"context"
+
 	"github.com/idfly/darius"
 
 	"github.com/shagabutdinov/shell"
 	"github.com/stretchr/testify/mock"
 )
 
+// newState returns mock state without parent and with empty configuration
 func newState() *state {
 	state := &state{}
+	state.On("Parent").Return(nil).Maybe()
+	state.On("Config").Return(map[interface{}]interface{}{}).Maybe()
 	return state
 }
 
 type state struct {
 	mock.Mock
-	task map[interface{}]interface{}
+	task   map[interface{}]interface{}
+	ctx    context.Context
+	dryRun bool
+	steps  map[string]map[interface{}]interface{}
+	expand func(interface{}) interface{}
 }
 
 func (mock *state) Args() map[interface{}]interface{} {
@@ -33,7 +42,46 @@ func (mock *state) Config() map[interface{}]interface{} {
 
 func (mock *state) Parent() (darius.State, bool) {
 	args := mock.Called()
-	return args.Get(0).(darius.State), true
+	parent, ok := args.Get(0).(darius.State)
+	return parent, ok
+}
+
+func (mock *state) Step(id string) (map[interface{}]interface{}, bool) {
+	value, ok := mock.steps[id]
+	return value, ok
+}
+
+func (mock *state) SetStep(id string, value map[interface{}]interface{}) {
+	if mock.steps == nil {
+		mock.steps = map[string]map[interface{}]interface{}{}
+	}
+
+	mock.steps[id] = value
+}
+
+func (mock *state) Context() context.Context {
+	if mock.ctx == nil {
+		return context.Background()
+	}
+
+	return mock.ctx
+}
+
+func (mock *state) WithContext(ctx context.Context) darius.State {
+	return &stateWithContext{mock, ctx}
+}
+
+type stateWithContext struct {
+	*state
+	ctx context.Context
+}
+
+func (mock *stateWithContext) Context() context.Context {
+	return mock.ctx
+}
+
+func (mock *state) DryRun() bool {
+	return mock.dryRun
 }
 
 func (mock *state) Execute(
@@ -50,6 +98,10 @@ func (mock *state) Expand(
 	value interface{},
 	recursive bool,
 ) (interface{}, error) {
+	if mock.expand != nil {
+		return mock.expand(value), nil
+	}
+
 	return value, nil
 }
 
@@ -64,11 +116,20 @@ func (mock *state) Spawn(
 	return mock, nil
 }
 
+func (mock *state) Fork(prefix string) darius.State {
+	return mock
+}
+
 func (mock *state) Call(task string, value map[interface{}]interface{}) error {
 	args := mock.Called(task, value)
 	return args.Error(0)
 }
 
+func (mock *state) RunTask(name string) error {
+	args := mock.Called(name)
+	return args.Error(0)
+}
+
 func (mock *state) Destroy() error {
 	return nil
 }
@@ -1,11 +1,9 @@
-package main
+package darius
 
 import (
 	"os"
 	"strings"
 
-	"github.com/idfly/darius"
-
 	"github.com/fatih/color"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -22,7 +20,8 @@ var (
 	colorLogEnsure      func(...interface{}) string
 	colorLogTaskFail    func(...interface{}) string
 	colorLogTaskSuccess func(...interface{}) string
-	formatters          map[darius.LogLevel]func(int, string) string
+	colorLogRetry       func(...interface{}) string
+	formatters          map[LogLevel]func(int, string) string
 )
 
 func init() {
@@ -40,54 +39,69 @@ func init() {
 	colorLogEnsure = colorize(color.Bold, color.FgYellow)
 	colorLogTaskFail = colorize(color.Bold, color.FgWhite, color.BgRed)
 	colorLogTaskSuccess = colorize(color.Bold, color.FgWhite, color.BgGreen)
+	colorLogRetry = colorize(color.Bold, color.FgYellow)
 
-	formatters = map[darius.LogLevel]func(int, string) string{
-		darius.LogName: func(level int, message string) string {
+	formatters = map[LogLevel]func(int, string) string{
+		LogName: func(level int, message string) string {
 			return format(level, "", "# ", message, colorLogName)
 		},
 
-		darius.LogSystem: func(level int, message string) string {
+		LogSystem: func(level int, message string) string {
 			return format(level, "", "% ", message, colorLogSystem)
 		},
 
-		darius.LogCommand: func(level int, message string) string {
+		LogCommand: func(level int, message string) string {
 			return format(level, "", "$ ", message, colorLogCommand)
 		},
 
-		darius.LogStdOut: func(level int, message string) string {
+		LogStdOut: func(level int, message string) string {
 			return format(level, "  ", "> ", message, colorLogStdOut)
 		},
 
-		darius.LogStdErr: func(level int, message string) string {
+		LogStdErr: func(level int, message string) string {
 			return format(level, "  ", "! ", message, colorLogStdErr)
 		},
 
-		darius.LogCommandFail: func(level int, message string) string {
+		LogCommandFail: func(level int, message string) string {
 			return format(level, "", " ** ", message, colorLogCommandFail)
 		},
 
-		darius.LogContext: func(level int, message string) string {
+		LogContext: func(level int, message string) string {
 			return format(level, "", "? ", message, colorLogContext)
 		},
 
-		darius.LogRescue: func(level int, message string) string {
+		LogRescue: func(level int, message string) string {
 			return format(level, "", "", message, colorLogRescue)
 		},
 
-		darius.LogEnsure: func(level int, message string) string {
+		LogEnsure: func(level int, message string) string {
 			return format(level, "", "", message, colorLogEnsure)
 		},
 
-		darius.LogTaskFail: func(level int, message string) string {
+		LogTaskFail: func(level int, message string) string {
 			return format(level, "", "", message, colorLogTaskFail)
 		},
 
-		darius.LogTaskSuccess: func(level int, message string) string {
+		LogTaskSuccess: func(level int, message string) string {
 			return format(level, "", "", message, colorLogTaskSuccess)
 		},
+
+		LogRetry: func(level int, message string) string {
+			return format(level, "", "~ ", message, colorLogRetry)
+		},
 	}
 }
 
+// FormatLog returns colorized log message indented by given level
+func FormatLog(level LogLevel, indent int, message string) string {
+	format, ok := formatters[level]
+	if !ok {
+		panic("unknown log level")
+	}
+
+	return format(indent, message)
+}
+
 func colorize(colors ...color.Attribute) func(...interface{}) string {
 	color := color.New(colors...)
 	color.EnableColor()
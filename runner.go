@@ -0,0 +1,250 @@
+package darius
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Job runs task in given state; jobs are called by name with State.Call
+type Job func(State, map[interface{}]interface{}) error
+
+type Result struct {
+	Task     string
+	Status   int
+	Started  time.Time
+	Duration time.Duration
+}
+
+// Runner runs tasks from Config or from ConfigFile
+type Runner struct {
+	Config     map[interface{}]interface{}
+	ConfigFile string
+	Loader     Config
+
+	Vars     map[interface{}]interface{}
+	Argv     []string
+	Args     map[interface{}]interface{}
+	Local    bool
+	Parallel bool
+	DryRun   bool
+
+	Output func(LogLevel, int, string)
+	Shell  ShellFactory
+	Jobs   map[string]Job
+
+	varsMerged bool
+}
+
+func (runner *Runner) Run(ctx context.Context, name string) (Result, error) {
+	result := Result{Task: name, Status: 1, Started: time.Now()}
+	defer func() {
+		result.Duration = time.Since(result.Started)
+	}()
+
+	err := ctx.Err()
+	if err != nil {
+		return result, err
+	}
+
+	tasks, err := runner.Tasks()
+	if err != nil {
+		return result, err
+	}
+
+	order, needs, err := plan(tasks, name)
+	if err != nil {
+		return result, err
+	}
+
+	state, err := runner.newState(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	defer state.Destroy()
+
+	if runner.Parallel && len(order) > 1 {
+		err = runParallel(state, tasks, order, needs)
+	} else {
+		err = state.RunTask(name)
+	}
+
+	if err != nil {
+		result.Status = exitStatus(err)
+		return result, err
+	}
+
+	result.Status = 0
+	return result, nil
+}
+
+// exitStatus returns exit status of failed command or 1 for other errors
+func exitStatus(err error) int {
+	var exitErr interface{ ExitStatus() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+
+	return 1
+}
+
+// Tasks loads configuration and returns expanded tasks section
+func (runner *Runner) Tasks() (map[interface{}]interface{}, error) {
+	err := runner.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return runner.createState(nil).tasks()
+}
+
+// Expand expands value with configuration vars and runner args
+func (runner *Runner) Expand(
+	value interface{},
+	recursive bool,
+) (interface{}, error) {
+	err := runner.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return runner.createState(nil).Expand(value, recursive)
+}
+
+// Log reports message at top level of output
+func (runner *Runner) Log(level LogLevel, message string) {
+	runner.output()(level, 0, message)
+}
+
+func (runner *Runner) load() error {
+	if runner.Config == nil {
+		err := runner.loadFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	if runner.Vars != nil && !runner.varsMerged {
+		runner.Config = Merge(runner.Config, map[interface{}]interface{}{
+			"vars": runner.Vars,
+		})
+
+		runner.varsMerged = true
+	}
+
+	return nil
+}
+
+func (runner *Runner) loadFile() error {
+	loader := runner.Loader
+	if loader.ReadFile == nil {
+		loader.ReadFile = ioutil.ReadFile
+	}
+
+	if loader.Glob == nil {
+		loader.Glob = filepath.Glob
+	}
+
+	configFile := runner.ConfigFile
+	if configFile == "" {
+		configFile = ".darius.yml"
+	}
+
+	config, err := loader.Load(configFile)
+	if err != nil {
+		return err
+	}
+
+	runner.Config = config
+	return nil
+}
+
+func (runner *Runner) newState(ctx context.Context) (*state, error) {
+	handle := &shellHandle{factory: runner.shellFactory()}
+	if !runner.DryRun {
+		_, err := handle.get()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state := runner.createState(handle)
+	state.ctx = context.WithValue(ctx, graceKey{}, &grace{})
+	return state, nil
+}
+
+type graceKey struct{}
+
+// grace holds deadline of cleanup after run is interrupted
+type grace struct {
+	once     sync.Once
+	deadline time.Time
+}
+
+// GraceContext returns context for cleanup of interrupted run; its deadline
+// is shared by all tasks of run
+func GraceContext(
+	ctx context.Context,
+	period time.Duration,
+) (context.Context, context.CancelFunc) {
+	current, ok := ctx.Value(graceKey{}).(*grace)
+	if !ok {
+		current = &grace{}
+	}
+
+	current.once.Do(func() {
+		current.deadline = time.Now().Add(period)
+	})
+
+	background := context.WithValue(context.Background(), graceKey{}, current)
+	return context.WithDeadline(background, current.deadline)
+}
+
+func (runner *Runner) createState(shell *shellHandle) *state {
+	args := map[interface{}]interface{}{}
+	for key, value := range runner.Args {
+		args[key] = value
+	}
+
+	state := &state{
+		config:     runner.Config,
+		argv:       runner.Argv,
+		args:       args,
+		runLocally: runner.Local,
+		dryRun:     runner.DryRun,
+		ctx:        context.Background(),
+		shell:      shell,
+		newShell:   runner.shellFactory(),
+		output:     runner.output(),
+		jobs:       runner.Jobs,
+		overrides:  runner.Vars,
+		steps:      newStepStore(),
+		completed:  newTaskSet(),
+	}
+
+	state.expression = NewExpression(state, state.expandExpression)
+	return state
+}
+
+func (runner *Runner) shellFactory() ShellFactory {
+	if runner.Shell != nil {
+		return runner.Shell
+	}
+
+	return NewShell
+}
+
+func (runner *Runner) output() func(LogLevel, int, string) {
+	if runner.Output != nil {
+		return runner.Output
+	}
+
+	return func(level LogLevel, indent int, message string) {
+		os.Stdout.WriteString(FormatLog(level, indent, message) + "\n")
+	}
+}
@@ -0,0 +1,152 @@
+package darius
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// createEnvironment sets environment variables and working directory of
+// commands from `env-file`, `env` and `dir` of task; they are inherited from
+// parent task and relative dir is resolved against dir of parent
+func (state *state) createEnvironment(task map[interface{}]interface{}) error {
+	env := map[string]string{}
+	for key, value := range state.env {
+		env[key] = value
+	}
+
+	_, ok := task["env-file"]
+	if ok {
+		raw, err := state.Expand(task["env-file"], false)
+		if err != nil {
+			return err
+		}
+
+		file, ok := raw.(string)
+		if !ok {
+			return errors.New("env-file should be string")
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		values, err := parseEnvFile(string(content))
+		if err != nil {
+			return errors.New("failed to parse " + file + ": " + err.Error())
+		}
+
+		for key, value := range values {
+			env[key] = value
+		}
+	}
+
+	_, ok = task["env"]
+	if ok {
+		raw, err := state.Expand(task["env"], true)
+		if err != nil {
+			return err
+		}
+
+		mapping, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return errors.New("env should be map")
+		}
+
+		for key, value := range mapping {
+			name := fmt.Sprint(key)
+			if !envName.MatchString(name) {
+				return errors.New("wrong environment variable name: " + name)
+			}
+
+			env[name] = stringify(value)
+		}
+	}
+
+	_, ok = task["dir"]
+	if ok {
+		raw, err := state.Expand(task["dir"], false)
+		if err != nil {
+			return err
+		}
+
+		dir, ok := raw.(string)
+		if !ok {
+			return errors.New("dir should be string")
+		}
+
+		if state.dir != "" && !path.IsAbs(dir) {
+			dir = path.Join(state.dir, dir)
+		}
+
+		state.dir = dir
+	}
+
+	state.env = env
+	return nil
+}
+
+// parseEnvFile reads KEY=VALUE lines; blank lines, comments and "export"
+// prefix are skipped and values could be quoted
+func parseEnvFile(content string) (map[string]string, error) {
+	result := map[string]string{}
+	for index, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		separator := strings.Index(line, "=")
+		if separator == -1 {
+			return nil, errors.New("line " + strconv.Itoa(index+1) +
+				" should be KEY=VALUE")
+		}
+
+		name := strings.TrimSpace(line[:separator])
+		if !envName.MatchString(name) {
+			return nil, errors.New("wrong environment variable name: " + name)
+		}
+
+		value := strings.TrimSpace(line[separator+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') &&
+			value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		result[name] = value
+	}
+
+	return result, nil
+}
+
+// wrapCommand runs command in subshell with environment and working
+// directory of task, so they do not leak to following commands in the same
+// shell
+func (state *state) wrapCommand(command string) string {
+	if len(state.env) == 0 && state.dir == "" {
+		return command
+	}
+
+	lines := []string{"("}
+	if state.dir != "" {
+		lines = append(lines, "cd "+quote(state.dir)+" || exit")
+	}
+
+	names := []string{}
+	for name := range state.env {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, "export "+name+"="+quote(state.env[name]))
+	}
+
+	return strings.Join(append(lines, command, ")"), "\n")
+}
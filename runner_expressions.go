@@ -0,0 +1,139 @@
+package darius
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shagabutdinov/shell"
+)
+
+var (
+	envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+func (state *state) expandExpression(
+	kind string,
+	expr string,
+) (interface{}, error) {
+	switch kind {
+	case "env":
+		return state.expandEnv(kind, expr, lookupLocalEnv)
+	case "remote_env":
+		return state.expandEnv(kind, expr, state.lookupRemoteEnv)
+	case "shell":
+		return state.expandShell(expr)
+	}
+
+	return nil, errors.New("unknown expression: " + expr)
+}
+
+// expandEnv resolves "NAME" or "NAME:-default" expression; default is used
+// if variable is not set or empty
+func (state *state) expandEnv(
+	kind string,
+	expr string,
+	lookup func(string) (string, bool, error),
+) (interface{}, error) {
+	name := expr
+	defaultValue := ""
+	hasDefault := false
+	index := strings.Index(expr, ":-")
+	if index != -1 {
+		name = expr[:index]
+		defaultValue = expr[index+2:]
+		hasDefault = true
+	}
+
+	if !envName.MatchString(name) {
+		return nil, errors.New("wrong environment variable name: " + name)
+	}
+
+	value, found, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasDefault && value == "" {
+		return defaultValue, nil
+	}
+
+	if !found {
+		return nil, &undefinedError{
+			name: kind + "." + name,
+			message: "environment variable " + name + " is not set; use ${" +
+				kind + "." + name + ":-default} to set default value",
+		}
+	}
+
+	return value, nil
+}
+
+func lookupLocalEnv(name string) (string, bool, error) {
+	value, found := os.LookupEnv(name)
+	return value, found, nil
+}
+
+// lookupRemoteEnv reads variable in shell of task; in dry run variable is
+// left as shell variable
+func (state *state) lookupRemoteEnv(name string) (string, bool, error) {
+	if state.dryRun {
+		return "$" + name, true, nil
+	}
+
+	stdout, _, status, err := state.capture("printenv " + name)
+	if err != nil {
+		return "", false, err
+	}
+
+	return stdout, status == 0, nil
+}
+
+// expandShell runs command in shell of task and returns its trimmed stdout;
+// in dry run command is left as shell substitution
+func (state *state) expandShell(command string) (interface{}, error) {
+	if state.dryRun {
+		return "$(" + command + ")", nil
+	}
+
+	stdout, stderr, status, err := state.capture(command)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != 0 {
+		message := "command " + command + " failed with status " +
+			strconv.Itoa(status)
+		if stderr != "" {
+			message += ": " + stderr
+		}
+
+		return nil, errors.New(message)
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
+
+// capture runs command in subshell, like command substitution does, and
+// returns its stdout and stderr
+func (state *state) capture(command string) (string, string, int, error) {
+	stdout := []string{}
+	stderr := []string{}
+	status, err := state.Execute(
+		"(\n"+command+"\n)",
+		func(kind shell.MessageType, message string) error {
+			if kind == shell.StdOut {
+				stdout = append(stdout, message)
+			} else {
+				stderr = append(stderr, message)
+			}
+
+			return nil
+		},
+	)
+
+	return strings.Join(stdout, "\n"), strings.Join(stderr, "\n"), status,
+		err
+}
@@ -0,0 +1,255 @@
+package darius
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+)
+
+// plan orders task and tasks from its "needs" so that every task follows
+// tasks it needs; every task is included once
+func plan(
+	tasks map[interface{}]interface{},
+	name string,
+) ([]string, map[string][]string, error) {
+	order := []string{}
+	needs := map[string][]string{}
+
+	var visit func(string, []string) error
+	visit = func(name string, stack []string) error {
+		for _, current := range stack {
+			if current == name {
+				return errors.New("recursive dependency detected: " +
+					strings.Join(append(stack, name), " -> "))
+			}
+		}
+
+		_, ok := needs[name]
+		if ok {
+			return nil
+		}
+
+		task, ok := tasks[name]
+		if !ok {
+			if len(stack) == 0 {
+				return errors.New("task " + name + " not found in " +
+					"configuration file")
+			}
+
+			return errors.New("task " + name + " needed by " +
+				stack[len(stack)-1] + " not found in configuration file")
+		}
+
+		list, err := taskNeeds(task)
+		if err != nil {
+			return errors.New("task " + name + ": " + err.Error())
+		}
+
+		stack = append(append([]string{}, stack...), name)
+		for _, need := range list {
+			err := visit(need, stack)
+			if err != nil {
+				return err
+			}
+		}
+
+		needs[name] = list
+		order = append(order, name)
+		return nil
+	}
+
+	err := visit(name, []string{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return order, needs, nil
+}
+
+// taskSet keeps names of tasks finished during run; it is shared by all
+// states of run
+type taskSet struct {
+	mutex sync.Mutex
+	names map[string]bool
+}
+
+func newTaskSet() *taskSet {
+	return &taskSet{names: map[string]bool{}}
+}
+
+func (set *taskSet) has(name string) bool {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	return set.names[name]
+}
+
+func (set *taskSet) add(name string) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	set.names[name] = true
+}
+
+// RunTask calls task from configuration after tasks from its needs; needed
+// tasks which already finished during run are not run again
+func (state *state) RunTask(name string) error {
+	tasks, err := state.tasks()
+	if err != nil {
+		state.Log(LogCommandFail, err.Error())
+		return err
+	}
+
+	order, _, err := plan(tasks, name)
+	if err != nil {
+		state.Log(LogCommandFail, err.Error())
+		return err
+	}
+
+	for _, current := range order {
+		if current != name && state.completed.has(current) {
+			continue
+		}
+
+		err := state.Call("call", userTask(tasks, current))
+		if err != nil {
+			return err
+		}
+
+		state.completed.add(current)
+	}
+
+	return nil
+}
+
+// tasks returns tasks section of configuration; it is expanded in root state,
+// so tasks run by runner and by other tasks are the same
+func (state *state) tasks() (map[interface{}]interface{}, error) {
+	root := state
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	raw, ok := root.config["tasks"]
+	if !ok {
+		return nil, errors.New("tasks section must be set in config")
+	}
+
+	expanded, err := root.Expand(raw, false)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, ok := expanded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("tasks section must be map")
+	}
+
+	return tasks, nil
+}
+
+// userTask returns task from configuration; combinations of matrix task
+// without name are labeled with its key
+func userTask(
+	tasks map[interface{}]interface{},
+	name string,
+) map[interface{}]interface{} {
+	task := CreateTask(tasks[name])
+	_, isMatrix := task["matrix"]
+	_, hasName := task["name"]
+	if isMatrix && !hasName {
+		task = Copy(task).(map[interface{}]interface{})
+		task["name"] = name
+	}
+
+	return task
+}
+
+func taskNeeds(task interface{}) ([]string, error) {
+	mapping, ok := task.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	raw, ok := mapping["needs"]
+	if !ok {
+		return nil, nil
+	}
+
+	str, ok := raw.(string)
+	if ok {
+		return []string{str}, nil
+	}
+
+	array, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New("needs should be string or array of strings")
+	}
+
+	result := []string{}
+	for _, element := range array {
+		str, ok := element.(string)
+		if !ok {
+			return nil, errors.New("needs should be string or array of " +
+				"strings")
+		}
+
+		result = append(result, str)
+	}
+
+	return result, nil
+}
+
+// runParallel runs every task as soon as tasks it needs are finished; task is
+// not run if any of tasks it needs failed
+func runParallel(
+	state *state,
+	tasks map[interface{}]interface{},
+	order []string,
+	needs map[string][]string,
+) error {
+	done := map[string]chan bool{}
+	errs := map[string]*error{}
+	for _, name := range order {
+		done[name] = make(chan bool)
+		errs[name] = new(error)
+	}
+
+	var wait sync.WaitGroup
+	for _, name := range order {
+		wait.Add(1)
+		go func(name string) {
+			defer wait.Done()
+			defer close(done[name])
+
+			for _, need := range needs[name] {
+				<-done[need]
+				if *errs[need] != nil {
+					*errs[name] = errors.New("task " + need + " needed by " +
+						name + " failed")
+					return
+				}
+			}
+
+			fork := state.Fork("[" + name + "] ")
+			*errs[name] = fork.Call("call", userTask(tasks, name))
+			if *errs[name] == nil {
+				state.completed.add(name)
+			}
+
+			err := fork.Destroy()
+			if err != nil {
+				log.Println(err)
+			}
+		}(name)
+	}
+
+	wait.Wait()
+
+	for _, name := range order {
+		if *errs[name] != nil {
+			return *errs[name]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,449 @@
+package darius
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/shagabutdinov/arguments"
+	"github.com/shagabutdinov/shell"
+)
+
+type state struct {
+	config     map[interface{}]interface{}
+	argv       []string
+	args       map[interface{}]interface{}
+	overrides  map[interface{}]interface{}
+	runLocally bool
+	dryRun     bool
+
+	ctx        context.Context
+	env        map[string]string
+	dir        string
+	shell      *shellHandle
+	newShell   ShellFactory
+	output     func(LogLevel, int, string)
+	expression *Expression
+
+	jobs map[string]Job
+	task map[interface{}]interface{}
+
+	level     int
+	parent    *state
+	buffer    *logBuffer
+	steps     *stepStore
+	completed *taskSet
+}
+
+// logBuffer keeps log of forked state until it is destroyed
+type logBuffer struct {
+	mutex   sync.Mutex
+	prefix  string
+	entries []logEntry
+	output  func(LogLevel, int, string)
+}
+
+type logEntry struct {
+	level   LogLevel
+	indent  int
+	message string
+}
+
+// forkOutput prevents logs of forked states from mixing
+var forkOutput sync.Mutex
+
+func (state *state) Config() map[interface{}]interface{} {
+	return state.config
+}
+
+// varOverrides returns vars overridden by runner
+func (state *state) varOverrides() map[interface{}]interface{} {
+	return state.overrides
+}
+
+func (state *state) Task() map[interface{}]interface{} {
+	return state.task
+}
+
+func (state *state) Args() map[interface{}]interface{} {
+	return state.args
+}
+
+func (state *state) Parent() (State, bool) {
+	return state.parent, state.parent != nil
+}
+
+func (state *state) Context() context.Context {
+	return state.ctx
+}
+
+// WithContext returns copy of state which runs commands with given context
+func (state *state) WithContext(ctx context.Context) State {
+	result := *state
+	result.ctx = ctx
+	result.expression = NewExpression(&result, result.expandExpression)
+	return &result
+}
+
+// DryRun reports that commands are only printed and not executed
+func (state *state) DryRun() bool {
+	return state.dryRun
+}
+
+// Execute runs command in shell of nearest state; if context of state is
+// done while command is running then shell is closed in order to kill
+// command and context error is returned after it exits
+func (state *state) Execute(
+	command string,
+	handler func(shell.MessageType, string) error,
+) (int, error) {
+	err := state.ctx.Err()
+	if err != nil {
+		return -1, err
+	}
+
+	if state.dryRun {
+		return 0, nil
+	}
+
+	var handle *shellHandle = nil
+	current := state
+
+	for {
+		handle = current.shell
+		if handle != nil {
+			break
+		}
+
+		if current.parent == nil {
+			return -1, errors.New("all shells closed")
+		}
+
+		current = current.parent
+	}
+
+	running, err := handle.get()
+	if err != nil {
+		return -1, err
+	}
+
+	var mutex sync.Mutex
+	interrupted := false
+	guarded := func(kind shell.MessageType, message string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if interrupted {
+			return state.ctx.Err()
+		}
+
+		return handler(kind, message)
+	}
+
+	type result struct {
+		status int
+		err    error
+	}
+
+	done := make(chan result, 1)
+	finished := make(chan struct{})
+	go func() {
+		status, err := running.Run(state.wrapCommand(command), guarded)
+		done <- result{status, err}
+		close(finished)
+	}()
+
+	select {
+	case result := <-done:
+		return result.status, result.err
+	case <-state.ctx.Done():
+		mutex.Lock()
+		interrupted = true
+		mutex.Unlock()
+		handle.interrupt(running, finished)
+		return -1, state.ctx.Err()
+	}
+}
+
+func (state *state) Expand(
+	value interface{},
+	recursive bool,
+) (interface{}, error) {
+	return state.expression.Expand(value, recursive)
+}
+
+func (state *state) Log(level LogLevel, message string) {
+	state.output(level, state.level, message)
+}
+
+func (state *state) Call(task string, value map[interface{}]interface{}) error {
+	taskCallback, ok := state.jobs[task]
+	if !ok {
+		message := `unknown job "` + task + `"`
+		state.Log(LogTaskFail, message)
+		return errors.New(message)
+	}
+
+	return taskCallback(state, value)
+}
+
+func (oldState *state) Spawn(
+	task map[interface{}]interface{},
+) (State, error) {
+	task = Copy(task).(map[interface{}]interface{})
+
+	result := &state{
+		config:     oldState.config,
+		runLocally: oldState.runLocally,
+		dryRun:     oldState.dryRun,
+		ctx:        oldState.ctx,
+		env:        oldState.env,
+		dir:        oldState.dir,
+		argv:       oldState.argv,
+		args:       Copy(oldState.args).(map[interface{}]interface{}),
+		overrides:  oldState.overrides,
+		parent:     oldState,
+		jobs:       oldState.jobs,
+		newShell:   oldState.newShell,
+		output:     oldState.output,
+		level:      oldState.level,
+		task:       task,
+		steps:      newStepStore(),
+		completed:  oldState.completed,
+	}
+
+	result.expression = NewExpression(result, result.expandExpression)
+
+	err := result.createArgs(task)
+	if err != nil {
+		return nil, err
+	}
+
+	err = expandTaskHeader(result, result.task)
+	if err != nil {
+		return nil, err
+	}
+
+	err = result.reportName(task)
+	if err != nil {
+		return nil, err
+	}
+
+	// condition is checked before connection so skipped task does not cost
+	// connection to host
+	when, ok := task["when"]
+	if ok {
+		ok, err = Evaluate(result, when)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			result.Log(LogSystem, "condition is false; skipped")
+			return nil, ErrSkipped
+		}
+	}
+
+	err = result.createShell(task)
+	if err != nil {
+		return nil, err
+	}
+
+	// body is expanded after connection and environment of task are created
+	// in order to resolve shell expressions on host and in dir of task
+	err = result.createEnvironment(task)
+	if err == nil {
+		err = expandTaskBody(result, result.task)
+	}
+
+	if err != nil {
+		result.Destroy()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Fork returns state with its own shell on the same host as current one;
+// log of forked state is prefixed and buffered until state is destroyed
+func (oldState *state) Fork(prefix string) State {
+	var host *Host = nil
+	for current := oldState; current != nil; current = current.parent {
+		if current.shell != nil {
+			host = current.shell.host
+			break
+		}
+	}
+
+	buffer := &logBuffer{prefix: prefix, output: oldState.output}
+	result := &state{
+		config:     oldState.config,
+		runLocally: oldState.runLocally,
+		dryRun:     oldState.dryRun,
+		ctx:        oldState.ctx,
+		env:        oldState.env,
+		dir:        oldState.dir,
+		argv:       oldState.argv,
+		args:       Copy(oldState.args).(map[interface{}]interface{}),
+		overrides:  oldState.overrides,
+		parent:     oldState,
+		jobs:       oldState.jobs,
+		shell:      &shellHandle{host: host, factory: oldState.newShell},
+		newShell:   oldState.newShell,
+		output:     buffer.append,
+		level:      oldState.level,
+		buffer:     buffer,
+		steps:      oldState.steps,
+		completed:  oldState.completed,
+	}
+
+	result.expression = NewExpression(result, result.expandExpression)
+	return result
+}
+
+func (buffer *logBuffer) append(level LogLevel, indent int, message string) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	buffer.entries = append(buffer.entries,
+		logEntry{level, indent, buffer.prefix + message})
+}
+
+func (buffer *logBuffer) flush() {
+	buffer.mutex.Lock()
+	entries := buffer.entries
+	buffer.entries = nil
+	buffer.mutex.Unlock()
+
+	forkOutput.Lock()
+	defer forkOutput.Unlock()
+	for _, entry := range entries {
+		buffer.output(entry.level, entry.indent, entry.message)
+	}
+}
+
+func (state *state) reportName(task map[interface{}]interface{}) error {
+	_, ok := task["name"]
+	if !ok {
+		return nil
+	}
+
+	name, ok := task["name"].(string)
+	if !ok {
+		return errors.New("task name should be string")
+	}
+
+	state.Log(LogName, name)
+	state.level += 1
+	return nil
+}
+
+func (state *state) createShell(task map[interface{}]interface{}) error {
+	if state.runLocally {
+		return nil
+	}
+
+	raw, ok := task["host"]
+	if !ok {
+		return nil
+	}
+
+	host, err := NewHost(raw)
+	if err != nil {
+		return err
+	}
+
+	if state.dryRun {
+		state.Log(LogSystem, "connection to "+host.Address+" skipped (dry "+
+			"run)")
+		return nil
+	}
+
+	state.Log(LogSystem, "connecting to "+host.Address+"...")
+	state.shell = &shellHandle{host: host, factory: state.newShell}
+	_, err = state.shell.get()
+	if err != nil {
+		state.shell = nil
+		return errors.New("failed to connect to " + host.Address + ": " +
+			err.Error())
+	} else {
+		state.Log(LogSystem, "connection established")
+	}
+
+	return nil
+}
+
+func (state *state) createArgs(task map[interface{}]interface{}) error {
+	_, ok := task["params"]
+	if ok {
+		var err error
+		task["params"], err = state.Expand(task["params"], true)
+		if err != nil {
+			return err
+		}
+
+		mapping, ok := task["params"].(map[interface{}]interface{})
+		if !ok {
+			return errors.New("params should be map")
+		}
+
+		for key, value := range mapping {
+			state.args[key] = value
+		}
+	}
+
+	_, ok = task["args"]
+	if !ok {
+		return nil
+	}
+
+	var err error
+	task["args"], err = state.Expand(task["args"], true)
+	if err != nil {
+		return err
+	}
+
+	mapping, ok := task["args"].(map[interface{}]interface{})
+	if !ok {
+		return errors.New("args must be map")
+	}
+
+	targetArgs := map[interface{}]interface{}{}
+	for key, value := range mapping {
+		_, ok := state.args[key]
+		if ok {
+			continue
+		}
+
+		targetArgs[key] = value
+	}
+
+	argsInfo, err := arguments.Create(targetArgs)
+	if err != nil {
+		return err
+	}
+
+	args, err := argsInfo.Parse(state.argv)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range args {
+		state.args[key] = value
+	}
+
+	return nil
+}
+
+func (state *state) Destroy() error {
+	if state.buffer != nil {
+		state.buffer.flush()
+	}
+
+	if state.shell != nil {
+		err := state.shell.close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,542 @@
+package darius
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shagabutdinov/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateExpandReturnsNonString(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand(true, false)
+	assert.NoError(test, err)
+	assert.Equal(test, true, result)
+}
+
+func TestStateExpandReturnsEscapedExpression(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand("$${test}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "${test}", result)
+}
+
+func TestStateExpandExpandsVariable(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"var": "VARIABLE"},
+	}
+
+	result, err := state.Expand("${vars.var}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VARIABLE", result)
+}
+
+func TestStateExpandPreventsRecursion(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"var": "${vars.var}"},
+	}
+
+	_, err := state.Expand("${vars.var}", false)
+	assert.Error(test, err)
+}
+
+func TestStateExpandExpandsSubVariable(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{
+			"VAR1": map[interface{}]interface{}{"VAR2": "SUBVAR"},
+		},
+	}
+
+	result, err := state.Expand("${vars.VAR1.VAR2}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "SUBVAR", result)
+}
+
+func TestStateExpandExpandsVariableFromTask(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"var": "WRONG"},
+	}
+
+	state.task = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{
+			"var": "VARIABLE",
+		},
+	}
+
+	result, err := state.Expand("${vars.var}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VARIABLE", result)
+}
+
+func TestStateExpandExpandsVariableFromParentTask(test *testing.T) {
+	testState := newTestState()
+	defer testState.Destroy()
+	testState.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"var": "WRONG"},
+	}
+
+	testState.parent = &state{
+		task: map[interface{}]interface{}{
+			"vars": map[interface{}]interface{}{
+				"var": "VARIABLE",
+			},
+		},
+	}
+
+	testState.task = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{
+			"WRONG": "WRONG",
+		},
+	}
+
+	result, err := testState.Expand("${vars.var}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VARIABLE", result)
+}
+
+func TestStateExpandReportsUnknownVariable(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"var": "WRONG"},
+	}
+
+	_, err := state.Expand("${vars.UNKNOWN}", false)
+	assert.Error(test, err)
+}
+
+func TestStateExpandExpandsArguments(test *testing.T) {
+	oldState := newTestState()
+	defer oldState.Destroy()
+	oldState.argv = []string{"-a", "VALUE"}
+	task := map[interface{}]interface{}{
+		"args": map[interface{}]interface{}{
+			"arg": map[interface{}]interface{}{
+				"name":     "arg",
+				"type":     "string",
+				"shortcut": "a",
+			},
+		},
+	}
+
+	newState, err := oldState.Spawn(task)
+	assert.NoError(test, err)
+	result, err := newState.Expand("${args.arg}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VALUE", result)
+}
+
+func TestStateExpandReturnsErrorIfRequiredArgumentIsNotSet(test *testing.T) {
+	oldState := newTestState()
+	defer oldState.Destroy()
+	oldState.argv = []string{}
+	task := map[interface{}]interface{}{
+		"args": map[interface{}]interface{}{
+			"arg": map[interface{}]interface{}{
+				"type":     "string",
+				"required": true,
+			},
+		},
+	}
+
+	_, err := oldState.Spawn(task)
+	assert.Error(test, err)
+}
+
+func TestStateDoesNotSpawnConnectionWhenRunningLocally(test *testing.T) {
+	oldState := newTestState()
+	defer oldState.Destroy()
+	oldState.runLocally = true
+	task := map[interface{}]interface{}{"host": "user@example.com"}
+	newState, err := oldState.Spawn(task)
+	assert.NoError(test, err)
+	assert.Nil(test, newState.(*state).shell)
+}
+
+func TestStateExecuteInterruptsCommandOnCancel(test *testing.T) {
+	root := newTestState()
+	defer root.Destroy()
+	ctx, cancel := context.WithCancel(context.Background())
+	state := root.WithContext(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	started := time.Now()
+	_, err := state.Execute("sleep 5", func(shell.MessageType, string) error {
+		return nil
+	})
+
+	assert.Equal(test, context.Canceled, err)
+	assert.True(test, time.Since(started) < time.Second)
+}
+
+func TestStateExecuteDoesNotRunCommandWithCancelledContext(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := state.WithContext(ctx).Execute("echo", nil)
+	assert.Equal(test, context.Canceled, err)
+}
+
+func TestStateForkBuffersLogUntilDestroyed(test *testing.T) {
+	messages := []string{}
+	state := newTestState()
+	state.output = func(level LogLevel, indent int, message string) {
+		messages = append(messages, message)
+	}
+
+	fork := state.Fork("[1] ")
+	fork.Log(LogCommand, "CMD")
+	assert.Empty(test, messages)
+	assert.NoError(test, fork.Destroy())
+	assert.Equal(test, []string{"[1] CMD"}, messages)
+}
+
+func TestStateForkRunsCommandInOwnShell(test *testing.T) {
+	root := newTestState()
+	defer root.Destroy()
+	fork := root.Fork("").(*state)
+	defer fork.Destroy()
+	_, err := fork.Execute("true", nil)
+	assert.NoError(test, err)
+	assert.NotSame(test, root.shell, fork.shell)
+	assert.NotNil(test, fork.shell.shell)
+}
+
+func TestStateExpandExpandsSameExpressionTwice(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"var": "VARIABLE"},
+	}
+
+	result, err := state.Expand("${vars.var} ${vars.var}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VARIABLE VARIABLE", result)
+}
+
+func TestStateExpandExpandsEnvironmentVariable(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	test.Setenv("DARIUS_TEST", "VALUE")
+	result, err := state.Expand("${env.DARIUS_TEST}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VALUE", result)
+}
+
+func TestStateExpandExpandsEnvironmentVariableDefault(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	test.Setenv("DARIUS_TEST", "")
+	result, err := state.Expand("${env.DARIUS_TEST:-8080}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "8080", result)
+}
+
+func TestStateExpandReportsMissingEnvironmentVariable(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	_, err := state.Expand("${env.DARIUS_MISSING}", false)
+	assert.EqualError(test, err, "environment variable DARIUS_MISSING is "+
+		"not set; use ${env.DARIUS_MISSING:-default} to set default value")
+}
+
+func TestStateExpandAppliesDefaultFilterToMissingEnvironmentVariable(
+	test *testing.T,
+) {
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand(`${env.DARIUS_MISSING | default "y"}`, false)
+	assert.NoError(test, err)
+	assert.Equal(test, "y", result)
+}
+
+func TestStateExpandExpandsRemoteEnvironmentVariable(test *testing.T) {
+	test.Setenv("DARIUS_TEST", "VALUE")
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand("${remote_env.DARIUS_TEST}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VALUE", result)
+}
+
+func TestStateExpandExpandsShellCommand(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand("v${shell echo ' 1.0 '}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "v1.0", result)
+}
+
+func TestStateExpandReportsFailedShellCommand(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	_, err := state.Expand("${shell echo ERROR 1>&2; exit 3}", false)
+	assert.EqualError(test, err, "command echo ERROR 1>&2; exit 3 failed "+
+		"with status 3: ERROR")
+}
+
+func TestStateExpandAppliesFilters(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"branch": "Feature/Test"},
+	}
+
+	result, err := state.Expand(`${vars.branch | lower | replace "/" "-"}`,
+		false)
+	assert.NoError(test, err)
+	assert.Equal(test, "feature-test", result)
+}
+
+func TestStateExpandQuotesValue(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"message": "it's; rm -rf /"},
+	}
+
+	result, err := state.Expand("echo ${vars.message | quote}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, `echo 'it'\''s; rm -rf /'`, result)
+}
+
+func TestStateExpandJoinsArray(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"tags": []interface{}{"a", "b"}},
+	}
+
+	result, err := state.Expand(`${vars.tags | join ","}`, false)
+	assert.NoError(test, err)
+	assert.Equal(test, "a,b", result)
+}
+
+func TestStateExpandAppliesDefaultToUndefinedVariable(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand(`${vars.missing | default "main"}`, false)
+	assert.NoError(test, err)
+	assert.Equal(test, "main", result)
+}
+
+func TestStateExpandKeepsPipeInShellCommand(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand("${shell echo HELLO | tr A-Z a-z | upper}",
+		false)
+	assert.NoError(test, err)
+	assert.Equal(test, "HELLO", result)
+}
+
+func TestStateExpandReportsWrongFilterArguments(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	_, err := state.Expand(`${vars.var | replace "/"}`, false)
+	assert.EqualError(test, err, "filter replace expects 2 arguments, got 1")
+}
+
+func TestStateSpawnSkipsTaskWhenConditionIsFalse(test *testing.T) {
+	oldState := newTestState()
+	defer oldState.Destroy()
+	oldState.argv = []string{"--env", "dev"}
+	task := map[interface{}]interface{}{
+		"when": "${args.env} == prod",
+		"host": "unreachable.invalid",
+		"args": map[interface{}]interface{}{
+			"env": map[interface{}]interface{}{
+				"name": "env",
+				"type": "string",
+			},
+		},
+	}
+
+	_, err := oldState.Spawn(task)
+	assert.Equal(test, ErrSkipped, err)
+}
+
+func TestStateExpandExpandsStepOfSibling(test *testing.T) {
+	root := newTestState()
+	defer root.Destroy()
+	first, err := root.Spawn(map[interface{}]interface{}{"id": "version"})
+	assert.NoError(test, err)
+	first.SetStep("version", map[interface{}]interface{}{
+		"json": map[interface{}]interface{}{"tag": "${vars.tag}"},
+	})
+
+	second, err := root.Spawn(map[interface{}]interface{}{})
+	assert.NoError(test, err)
+	result, err := second.Expand("v${steps.version.json.tag}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "v${vars.tag}", result)
+}
+
+func TestStateExpandReportsUndefinedStep(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	_, err := state.Expand("${steps.build.stdout}", false)
+	assert.EqualError(test, err, "undefined variable steps.build.stdout")
+}
+
+func TestStateExecuteAppliesEnvironmentAndDir(test *testing.T) {
+	dir := test.TempDir()
+	file := filepath.Join(dir, ".env")
+	assert.NoError(test, os.WriteFile(file, []byte("# comment\n"+
+		"export FILE='from file'\nNAME=file\n"), 0644))
+
+	root := newTestState()
+	defer root.Destroy()
+	parent, err := root.Spawn(map[interface{}]interface{}{
+		"env-file": file,
+		"env":      map[interface{}]interface{}{"NAME": "parent"},
+		"dir":      dir,
+	})
+
+	assert.NoError(test, err)
+	child, err := parent.Spawn(map[interface{}]interface{}{
+		"env": map[interface{}]interface{}{"NAME": "it's child"},
+		"dir": "sub",
+	})
+
+	assert.NoError(test, err)
+	assert.NoError(test, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	result, err := child.Expand("${shell echo $FILE $NAME; pwd}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "from file it's child\n"+filepath.Join(dir, "sub"),
+		result)
+
+	result, err = root.Expand("${shell echo x$NAME; pwd}", false)
+	assert.NoError(test, err)
+	assert.NotContains(test, result, "child")
+	assert.NotContains(test, result, "sub")
+}
+
+func TestStateSpawnExpandsCommandInDirOfTask(test *testing.T) {
+	dir := test.TempDir()
+	root := newTestState()
+	defer root.Destroy()
+	state, err := root.Spawn(map[interface{}]interface{}{
+		"dir":     dir,
+		"command": "${shell pwd}",
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, dir, state.Task()["command"])
+}
+
+func TestStateExecuteKeepsDirOfPreviousCommandWithoutEnvironment(
+	test *testing.T,
+) {
+	dir := test.TempDir()
+	state := newTestState()
+	defer state.Destroy()
+	_, err := state.Execute("cd "+dir, func(shell.MessageType, string) error {
+		return nil
+	})
+
+	assert.NoError(test, err)
+	result, err := state.Expand("${shell pwd}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, dir, result)
+}
+
+func TestStateExpandQuotesUntrustedArguments(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.args["branch"] = Untrusted("it's; rm")
+
+	result, err := state.Expand("echo ${args.branch | upper}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, `echo 'IT'\''S; RM'`, result)
+
+	result, err = state.Expand("echo ${args.branch | quote}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, `echo 'it'\''s; rm'`, result)
+}
+
+func TestStateExpandExpandsArgumentValues(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.args["message"] = "${vars.name}"
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"name": "NAME"},
+	}
+
+	result, err := state.Expand("echo ${args.message}", true)
+	assert.NoError(test, err)
+	assert.Equal(test, "echo NAME", result)
+}
+
+func TestStateExpandDoesNotExpandUntrustedArgumentValues(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.args["message"] = Untrusted("${shell echo INJECTED}")
+	state.config = map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"message": "${args.message}"},
+	}
+
+	result, err := state.Expand("${vars.message | quote}", true)
+	assert.NoError(test, err)
+	assert.Equal(test, "'${shell echo INJECTED}'", result)
+}
+
+func TestStateExpandSplitsSingleQuotedFilterArguments(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	result, err := state.Expand("${vars.missing | default 'a b'}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "a b", result)
+}
+
+func TestStateExpandReturnsEscapedValueAsIs(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	value := "a ${shell echo INJECTED} $${b} $c"
+	result, err := state.Expand(Escape(value), true)
+	assert.NoError(test, err)
+	assert.Equal(test, value, result)
+}
+
+func TestStateExpandPrefersOverriddenVarsToVarsOfTask(test *testing.T) {
+	root := newTestState()
+	defer root.Destroy()
+	root.overrides = map[interface{}]interface{}{"env": "staging"}
+	state, err := root.Spawn(map[interface{}]interface{}{
+		"vars": map[interface{}]interface{}{"env": "dev", "region": "eu"},
+	})
+
+	assert.NoError(test, err)
+	result, err := state.Expand("${vars.env} ${vars.region}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "staging eu", result)
+}
+
+func TestStateExpandDoesNotExpandStepOutput(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	state.SetStep("build", map[interface{}]interface{}{
+		"stdout": "${shell echo INJECTED}",
+	})
+
+	result, err := state.Expand("${steps.build.stdout}", true)
+	assert.NoError(test, err)
+	assert.Equal(test, "${shell echo INJECTED}", result)
+}
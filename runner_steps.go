@@ -0,0 +1,52 @@
+package darius
+
+import (
+	"sync"
+)
+
+// stepStore keeps outputs of subtasks with `id`; forked states share store of
+// their parent, so outputs of parallel commands are visible after them
+type stepStore struct {
+	mutex  sync.Mutex
+	values map[string]map[interface{}]interface{}
+}
+
+func newStepStore() *stepStore {
+	return &stepStore{values: map[string]map[interface{}]interface{}{}}
+}
+
+func (store *stepStore) get(id string) (map[interface{}]interface{}, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	value, ok := store.values[id]
+	return value, ok
+}
+
+func (store *stepStore) set(id string, value map[interface{}]interface{}) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.values[id] = value
+}
+
+// Step returns output of step stored in current state or its parents
+func (state *state) Step(id string) (map[interface{}]interface{}, bool) {
+	for current := state; current != nil; current = current.parent {
+		value, ok := current.steps.get(id)
+		if ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// SetStep stores output of step in parent state so it is visible to
+// following siblings of task
+func (state *state) SetStep(id string, value map[interface{}]interface{}) {
+	target := state
+	if state.parent != nil {
+		target = state.parent
+	}
+
+	target.steps.set(id, value)
+}
@@ -0,0 +1,258 @@
+package darius
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type logMessage struct {
+	level   LogLevel
+	indent  int
+	message string
+}
+
+type exitTestError int
+
+func (err exitTestError) ExitStatus() int {
+	return int(err)
+}
+
+func (err exitTestError) Error() string {
+	return "EXIT"
+}
+
+func newTestRunner(config string) (*Runner, *[]logMessage) {
+	messages := &[]logMessage{}
+	runner := &Runner{
+		Loader: Config{
+			ReadFile: func(string) ([]byte, error) {
+				return []byte(config), nil
+			},
+		},
+		Output: func(level LogLevel, indent int, message string) {
+			*messages = append(*messages, logMessage{level, indent, message})
+		},
+		Jobs: map[string]Job{
+			"call": func(state State, task map[interface{}]interface{}) error {
+				name, ok := task["run"].(string)
+				if ok {
+					return state.RunTask(name)
+				}
+
+				state.Log(LogCommand, task["command"].(string))
+				if task["command"] == "FAIL" {
+					return errors.New("FAILED")
+				}
+
+				if task["command"] == "EXIT" {
+					return exitTestError(3)
+				}
+
+				return nil
+			},
+		},
+	}
+
+	return runner, messages
+}
+
+func TestRunnerRunsTask(test *testing.T) {
+	runner, messages := newTestRunner("tasks: {T: TASK}")
+	result, err := runner.Run(context.Background(), "T")
+	assert.NoError(test, err)
+	assert.Equal(test, "T", result.Task)
+	assert.Equal(test, 0, result.Status)
+	assert.Equal(test, []logMessage{{LogCommand, 0, "TASK"}}, *messages)
+}
+
+func TestRunnerReturnsTaskError(test *testing.T) {
+	runner, _ := newTestRunner("tasks: {T: FAIL}")
+	result, err := runner.Run(context.Background(), "T")
+	assert.EqualError(test, err, "FAILED")
+	assert.Equal(test, 1, result.Status)
+}
+
+func TestRunnerReturnsExitStatusOfFailedCommand(test *testing.T) {
+	runner, _ := newTestRunner("tasks: {T: EXIT}")
+	result, err := runner.Run(context.Background(), "T")
+	assert.Error(test, err)
+	assert.Equal(test, 3, result.Status)
+}
+
+func TestRunnerReportsUnknownTask(test *testing.T) {
+	runner, _ := newTestRunner("tasks: {T: TASK}")
+	_, err := runner.Run(context.Background(), "UNKNOWN")
+	assert.EqualError(test, err, "task UNKNOWN not found in configuration "+
+		"file")
+}
+
+func TestRunnerReportsMissingTasks(test *testing.T) {
+	runner, _ := newTestRunner("vars: {}")
+	_, err := runner.Run(context.Background(), "T")
+	assert.EqualError(test, err, "tasks section must be set in config")
+}
+
+func TestRunnerUsesLoadedConfig(test *testing.T) {
+	runner, _ := newTestRunner("")
+	runner.Config = map[interface{}]interface{}{
+		"tasks": map[interface{}]interface{}{"T": "TASK"},
+	}
+
+	_, err := runner.Run(context.Background(), "T")
+	assert.NoError(test, err)
+}
+
+func TestRunnerDoesNotRunCancelledContext(test *testing.T) {
+	runner, messages := newTestRunner("tasks: {T: TASK}")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := runner.Run(ctx, "T")
+	assert.Equal(test, context.Canceled, err)
+	assert.Empty(test, *messages)
+}
+
+func TestRunnerPassesArgs(test *testing.T) {
+	runner, _ := newTestRunner("tasks: {T: TASK}")
+	runner.Args = map[interface{}]interface{}{"arg": "VALUE"}
+	result, err := runner.Expand("${args.arg}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "VALUE", result)
+}
+
+func TestRunnerRunsNeededTasksOnce(test *testing.T) {
+	runner, messages := newTestRunner("tasks: {" +
+		"update: UPDATE, " +
+		"lint: {needs: update, command: LINT}, " +
+		"build: {needs: [update, lint], command: BUILD}}")
+
+	_, err := runner.Run(context.Background(), "build")
+	assert.NoError(test, err)
+	assert.Equal(test, []logMessage{
+		{LogCommand, 0, "UPDATE"},
+		{LogCommand, 0, "LINT"},
+		{LogCommand, 0, "BUILD"},
+	}, *messages)
+}
+
+func TestRunnerStopsOnFailedNeededTask(test *testing.T) {
+	runner, messages := newTestRunner("tasks: {" +
+		"update: FAIL, build: {needs: update, command: BUILD}}")
+
+	_, err := runner.Run(context.Background(), "build")
+	assert.EqualError(test, err, "FAILED")
+	assert.Equal(test, []logMessage{{LogCommand, 0, "FAIL"}}, *messages)
+}
+
+func TestRunnerReportsRecursiveDependency(test *testing.T) {
+	runner, _ := newTestRunner("tasks: {" +
+		"a: {needs: b, command: A}, b: {needs: [a], command: B}}")
+
+	_, err := runner.Run(context.Background(), "a")
+	assert.EqualError(test, err, "recursive dependency detected: a -> b -> a")
+}
+
+func TestRunnerReportsUnknownNeededTask(test *testing.T) {
+	runner, _ := newTestRunner("tasks: {a: {needs: b, command: A}}")
+	_, err := runner.Run(context.Background(), "a")
+	assert.EqualError(test, err, "task b needed by a not found in "+
+		"configuration file")
+}
+
+func TestRunnerRunsNeededTasksInParallel(test *testing.T) {
+	runner, messages := newTestRunner("tasks: {" +
+		"a: A, b: FAIL, c: {needs: [a, b], command: C}}")
+	runner.Parallel = true
+
+	_, err := runner.Run(context.Background(), "c")
+	assert.EqualError(test, err, "FAILED")
+	assert.ElementsMatch(test, []logMessage{
+		{LogCommand, 0, "[a] A"},
+		{LogCommand, 0, "[b] FAIL"},
+	}, *messages)
+}
+
+func TestRunnerRunsNeedsOfTaskCalledFromTask(test *testing.T) {
+	runner, messages := newTestRunner("tasks: {a: A, b: {needs: a, " +
+		"command: B}, c: {needs: a, run: b}}")
+
+	_, err := runner.Run(context.Background(), "c")
+	assert.NoError(test, err)
+	assert.Equal(test, []logMessage{
+		{LogCommand, 0, "A"},
+		{LogCommand, 0, "B"},
+	}, *messages)
+}
+
+func TestRunnerRunsTaskFromExpandedTasksCalledFromTask(test *testing.T) {
+	runner, messages := newTestRunner("vars: {tasks: {a: A, b: {run: a}}}\n" +
+		"tasks: ${vars.tasks}")
+
+	_, err := runner.Run(context.Background(), "b")
+	assert.NoError(test, err)
+	assert.Equal(test, []logMessage{{LogCommand, 0, "A"}}, *messages)
+}
+
+func TestRunnerDoesNotRunNeedsOfCalledTaskAgain(test *testing.T) {
+	runner, messages := newTestRunner("tasks: {a: A, b: {needs: a, " +
+		"command: B}, c: {run: b}, d: {needs: [a, b, c], run: b}}")
+
+	_, err := runner.Run(context.Background(), "d")
+	assert.NoError(test, err)
+	assert.Equal(test, []logMessage{
+		{LogCommand, 0, "A"},
+		{LogCommand, 0, "B"},
+		{LogCommand, 0, "B"},
+		{LogCommand, 0, "B"},
+	}, *messages)
+}
+
+func TestRunnerOverridesVars(test *testing.T) {
+	runner, _ := newTestRunner("vars: {env: dev, image: {name: app, " +
+		"tag: latest}}\ntasks: {}")
+	runner.Vars = map[interface{}]interface{}{
+		"image": map[interface{}]interface{}{"tag": "abc123"},
+	}
+
+	result, err := runner.Expand("${vars.env} ${vars.image.name}:"+
+		"${vars.image.tag}", false)
+	assert.NoError(test, err)
+	assert.Equal(test, "dev app:abc123", result)
+}
+
+func TestGraceContextSharesDeadlineOfRun(test *testing.T) {
+	state := newTestState()
+	defer state.Destroy()
+	ctx, cancel := context.WithCancel(state.Context())
+	cancel()
+
+	first, cancelFirst := GraceContext(ctx, time.Minute)
+	defer cancelFirst()
+	time.Sleep(10 * time.Millisecond)
+	second, cancelSecond := GraceContext(first, time.Minute)
+	defer cancelSecond()
+
+	firstDeadline, _ := first.Deadline()
+	secondDeadline, _ := second.Deadline()
+	assert.Equal(test, firstDeadline, secondDeadline)
+}
+
+func TestRunnerNamesMatrixTaskWithItsKey(test *testing.T) {
+	runner, _ := newTestRunner("tasks: {build: {matrix: {go: [1]}, " +
+		"command: B}}")
+	tasks := []map[interface{}]interface{}{}
+	runner.Jobs["call"] = func(
+		state State,
+		task map[interface{}]interface{},
+	) error {
+		tasks = append(tasks, task)
+		return nil
+	}
+
+	_, err := runner.Run(context.Background(), "build")
+	assert.NoError(test, err)
+	assert.Equal(test, "build", tasks[0]["name"])
+}
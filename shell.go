@@ -0,0 +1,153 @@
+package darius
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/shagabutdinov/shell"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	logLineLimit = 2048
+	closeTimeout = 5 * time.Second
+)
+
+// ShellFactory creates shell; host is nil for local shell
+type ShellFactory func(host *Host) (shell.Shell, error)
+
+type Host struct {
+	Address string
+	Key     string
+}
+
+// NewHost creates host from "host" section of task
+func NewHost(raw interface{}) (*Host, error) {
+	hostMapping, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		hostString, ok := raw.(string)
+		if !ok {
+			return nil, errors.New("host should be string or map")
+		}
+
+		return &Host{Address: hostString}, nil
+	}
+
+	hostRaw, ok := hostMapping["host"]
+	if !ok {
+		return nil, errors.New("host must be set in host section")
+	}
+
+	address, ok := hostRaw.(string)
+	if !ok {
+		return nil, errors.New("host must be string")
+	}
+
+	result := &Host{Address: address}
+	keyFileRaw, ok := hostMapping["key"]
+	if ok {
+		result.Key, ok = keyFileRaw.(string)
+		if !ok {
+			return nil, errors.New("keyfile should be string")
+		}
+	}
+
+	return result, nil
+}
+
+// NewShell is default ShellFactory
+func NewShell(host *Host) (shell.Shell, error) {
+	if host == nil {
+		return shell.NewLocal(shell.LocalConfig{LineLimit: 1024})
+	}
+
+	keyFile := host.Key
+	if keyFile == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+
+		keyFile = usr.HomeDir + "/.ssh/id_rsa"
+	}
+
+	keyContents, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ssh.ParsePrivateKey(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	return shell.NewRemote(shell.RemoteConfig{
+		Address:   host.Address,
+		Auth:      []ssh.AuthMethod{ssh.PublicKeys(key)},
+		LineLimit: logLineLimit,
+	})
+}
+
+// shellHandle owns shell of state and reopens it after interrupt
+type shellHandle struct {
+	mutex   sync.Mutex
+	host    *Host
+	factory ShellFactory
+	shell   shell.Shell
+}
+
+func (handle *shellHandle) get() (shell.Shell, error) {
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+
+	if handle.shell == nil {
+		var err error
+		handle.shell, err = handle.factory(handle.host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return handle.shell, nil
+}
+
+// interrupt closes shell of running command and waits until command exits
+func (handle *shellHandle) interrupt(
+	current shell.Shell,
+	finished <-chan struct{},
+) {
+	handle.mutex.Lock()
+	if handle.shell == current {
+		handle.shell = nil
+		err := current.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	handle.mutex.Unlock()
+
+	select {
+	case <-finished:
+	case <-time.After(closeTimeout):
+		log.Println("command did not finish after its shell was closed")
+	}
+}
+
+func (handle *shellHandle) close() error {
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+
+	if handle.shell == nil {
+		return nil
+	}
+
+	err := handle.shell.Close()
+	handle.shell = nil
+	return err
+}
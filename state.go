@@ -1,6 +1,10 @@
 package darius
 
-import "github.com/shagabutdinov/shell"
+import (
+	"context"
+
+	"github.com/shagabutdinov/shell"
+)
 
 type LogLevel int
 
@@ -16,10 +20,12 @@ const (
 	LogEnsure
 	LogTaskFail
 	LogTaskSuccess
+	LogRetry
 )
 
 type State interface {
 	Call(string, map[interface{}]interface{}) error
+	RunTask(string) error
 	Log(LogLevel, string)
 	Execute(string, func(shell.MessageType, string) error) (int, error)
 	Expand(interface{}, bool) (interface{}, error)
@@ -28,7 +34,14 @@ type State interface {
 	Task() map[interface{}]interface{}
 	Args() map[interface{}]interface{}
 	Parent() (State, bool)
+	Step(string) (map[interface{}]interface{}, bool)
+	SetStep(string, map[interface{}]interface{})
+
+	Context() context.Context
+	WithContext(context.Context) State
+	DryRun() bool
 
 	Spawn(map[interface{}]interface{}) (State, error)
+	Fork(string) State
 	Destroy() error
 }
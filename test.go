@@ -0,0 +1,19 @@
+package darius
+
+import (
+	"context"
+)
+
+func newTestState() *state {
+	runner := &Runner{
+		Config: map[interface{}]interface{}{},
+		Output: func(LogLevel, int, string) {},
+	}
+
+	state, err := runner.newState(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	return state
+}
@@ -9,64 +9,62 @@ func CreateTask(task interface{}) map[interface{}]interface{} {
 	return map[interface{}]interface{}{"command": task}
 }
 
+// ExpandTask expands expressions in name, host, context, job, command and
+// script of task; rescue and ensure are expanded when they are called as
+// subtasks, so every value is expanded only once
 func ExpandTask(
 	state State,
 	task map[interface{}]interface{},
 ) error {
-	var err error
-	var ok bool
-
-	_, ok = task["name"]
-	if ok {
-		task["name"], err = state.Expand(task["name"], true)
-		if err != nil {
-			return err
-		}
+	err := expandTaskHeader(state, task)
+	if err != nil {
+		return err
 	}
 
-	_, ok = task["host"]
-	if ok {
-		task["host"], err = state.Expand(task["host"], true)
-		if err != nil {
-			return err
-		}
-	}
+	return expandTaskBody(state, task)
+}
 
-	_, ok = task["context"]
-	if ok {
-		task["context"], err = state.Expand(task["context"], false)
-		if err != nil {
-			return err
-		}
-	}
+// expandTaskHeader expands keys which are required before connection to host
+func expandTaskHeader(
+	state State,
+	task map[interface{}]interface{},
+) error {
+	return expandTaskKeys(state, task, []string{"name", "host"}, true)
+}
 
-	_, ok = task["job"]
-	if ok {
-		task["job"], err = state.Expand(task["job"], false)
-		if err != nil {
-			return err
-		}
+func expandTaskBody(
+	state State,
+	task map[interface{}]interface{},
+) error {
+	err := expandTaskKeys(state, task, []string{"context", "job"}, false)
+	if err != nil {
+		return err
 	}
 
-	_, ok = task["command"]
-	if ok {
-		task["command"], err = state.Expand(task["command"], false)
-		if err != nil {
-			return err
-		}
+	// command of foreach is expanded for every item separately as it refers
+	// to item in args
+	keys := []string{"command", "script"}
+	if task["job"] == "foreach" {
+		keys = keys[1:]
 	}
 
-	_, ok = task["rescue"]
-	if ok {
-		task["rescue"], err = state.Expand(task["rescue"], false)
-		if err != nil {
-			return err
+	return expandTaskKeys(state, task, keys, false)
+}
+
+func expandTaskKeys(
+	state State,
+	task map[interface{}]interface{},
+	keys []string,
+	recursive bool,
+) error {
+	for _, key := range keys {
+		_, ok := task[key]
+		if !ok {
+			continue
 		}
-	}
 
-	_, ok = task["ensure"]
-	if ok {
-		task["ensure"], err = state.Expand(task["ensure"], false)
+		var err error
+		task[key], err = state.Expand(task[key], recursive)
 		if err != nil {
 			return err
 		}
@@ -98,3 +96,26 @@ func Copy(value interface{}) interface{} {
 
 	return value
 }
+
+// Merge returns copy of base with values from override; nested maps are
+// merged recursively
+func Merge(
+	base map[interface{}]interface{},
+	override map[interface{}]interface{},
+) map[interface{}]interface{} {
+	result := Copy(base).(map[interface{}]interface{})
+	for key, value := range override {
+		overrideMapping, ok := value.(map[interface{}]interface{})
+		if ok {
+			baseMapping, ok := result[key].(map[interface{}]interface{})
+			if ok {
+				result[key] = Merge(baseMapping, overrideMapping)
+				continue
+			}
+		}
+
+		result[key] = Copy(value)
+	}
+
+	return result
+}